@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+// config is a structured (YAML or JSON) configuration file, e.g.:
+//
+//  layout: package
+//  apihost: https://api.example.com
+//  apihosts:
+//    staging: https://staging.example.com:8443
+//  exclude:
+//    option: mycorp.api.internal
+//    symbols: [mycorp.internal.*]
+//  links:
+//    google.protobuf: https://developers.google.com/protocol-buffers/docs/reference/google.protobuf
+//  data:
+//    Title: Library API
+//
+// Each field maps onto the plugin parameter of the same name, see params.
+type config struct {
+	Template    string   `json:"template"`
+	FileMap     string   `json:"filemap"`
+	Layout      string   `json:"layout"`
+	Format      string   `json:"format"`
+	Root        string   `json:"root"`
+	SearchPath  []string `json:"search-path"`
+	DumpFileMap string   `json:"dump-filemap"`
+	Funcs       string   `json:"funcs"`
+
+	// APIHost is the default API host, and APIHosts are those of the other
+	// environments by name (i.e. the apihost.ENV parameters).
+	APIHost  string            `json:"apihost"`
+	APIHosts map[string]string `json:"apihosts"`
+
+	Exclude struct {
+		Symbols []string `json:"symbols"`
+		Option  string   `json:"option"`
+		Tag     string   `json:"tag"`
+	} `json:"exclude"`
+
+	OpenAPI struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"openapi"`
+
+	ErrorFormat       string `json:"error-format"`
+	ErrorReport       string `json:"error-report"`
+	KeepGoing         *bool  `json:"keep-going"`
+	ErrorPlaceholders *bool  `json:"error-placeholders"`
+	Strict            *bool  `json:"strict"`
+
+	// Links maps packages or symbols to their external documentation (i.e.
+	// the link.NAME parameters).
+	Links map[string]string `json:"links"`
+
+	// Params are custom parameters for templates.
+	Params map[string]string `json:"params"`
+
+	// Data is passed to every template as .Data.
+	Data map[string]interface{} `json:"data"`
+}
+
+// isConfigFile tells if the conf file is a structured configuration file
+// (rather than a text file of comma-separated parameters), by its extension.
+func isConfigFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// readConfig reads the structured configuration file at the given path.
+// Unknown fields are reported as errors.
+func readConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	c := &config{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// params returns the plugin parameters of the configuration, other than its
+// Data.
+func (c *config) params() *util.Params {
+	p := &util.Params{}
+	add := func(key, value string) {
+		if value != "" {
+			p.Add(key, value)
+		}
+	}
+	addBool := func(key string, value *bool) {
+		if value != nil {
+			p.Add(key, strconv.FormatBool(*value))
+		}
+	}
+	addMap := func(prefix string, m map[string]string) {
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p.Add(prefix+k, m[k])
+		}
+	}
+	add("template", c.Template)
+	add("filemap", c.FileMap)
+	add("layout", c.Layout)
+	add("format", c.Format)
+	add("root", c.Root)
+	for _, s := range c.SearchPath {
+		add("search-path", s)
+	}
+	add("dump-filemap", c.DumpFileMap)
+	add("funcs", c.Funcs)
+	add("apihost", c.APIHost)
+	addMap("apihost.", c.APIHosts)
+	for _, s := range c.Exclude.Symbols {
+		add("exclude", s)
+	}
+	add("exclude-option", c.Exclude.Option)
+	add("exclude-tag", c.Exclude.Tag)
+	add("openapi-title", c.OpenAPI.Title)
+	add("openapi-version", c.OpenAPI.Version)
+	add("error-format", c.ErrorFormat)
+	add("error-report", c.ErrorReport)
+	addBool("keep-going", c.KeepGoing)
+	addBool("error-placeholders", c.ErrorPlaceholders)
+	addBool("strict", c.Strict)
+	addMap("link.", c.Links)
+	addMap("", c.Params)
+	return p
+}
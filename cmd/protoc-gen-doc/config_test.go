@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protoc-gen-doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "docs.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+# Public API docs.
+layout: package
+search-path: [templates/, shared/]
+apihost: https://api.example.com
+apihosts:
+  staging: https://staging.example.com:8443
+exclude:
+  option: mycorp.api.internal
+  symbols: [mycorp.internal.*, "*.Debug*"]
+keep-going: true
+strict: false
+links:
+  google.protobuf: https://developers.google.com/protocol-buffers/docs/reference/google.protobuf
+params:
+  style: dark
+data:
+  Title: Library API
+  Tags: [public]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isConfigFile(path) || isConfigFile("docs.conf") {
+		t.Fatal("expected only .yaml files to be config files")
+	}
+	c, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parameters given on the command line override the configuration.
+	params, err := util.ParseParamString("layout=file,exclude=other.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.Extend(c.params())
+	var got []string
+	for _, k := range params.Keys() {
+		got = append(got, k+"="+strings.Join(params.Values(k), "|"))
+	}
+	want := []string{
+		"layout=file",
+		"exclude=other.*",
+		"search-path=templates/|shared/",
+		"apihost=https://api.example.com",
+		"apihost.staging=https://staging.example.com:8443",
+		"exclude-option=mycorp.api.internal",
+		"keep-going=true",
+		"strict=false",
+		"link.google.protobuf=https://developers.google.com/protocol-buffers/docs/reference/google.protobuf",
+		"style=dark",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Only the custom template parameter is unknown.
+	errs, _ := paramSchema.Validate(params).(util.ParamErrors)
+	if len(errs) != 1 || errs[0].Name != "style" || errs[0].Suggestion != "" {
+		t.Fatalf("got %v", errs)
+	}
+	if c.Data["Title"] != "Library API" || len(c.Data["Tags"].([]interface{})) != 1 {
+		t.Fatalf("got data %v", c.Data)
+	}
+
+	// Unknown fields are reported.
+	if err := ioutil.WriteFile(path, []byte("templte: doc.html\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readConfig(path); err == nil || !strings.Contains(err.Error(), "templte") {
+		t.Fatalf("expected an unknown field error, got %v", err)
+	}
+}
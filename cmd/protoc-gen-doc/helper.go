@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/prototools/tmpl"
+)
+
+// helper is an out-of-process helper, which provides extra template functions
+// and post-processes generated files. It is configured by the "funcs"
+// parameter, and speaks a small protocol of newline-delimited JSON messages
+// over its stdin (requests) and stdout (responses), one response per request:
+//
+//  -> {"method": "describe"}
+//  <- {"functions": [{"name": "catalogURL", "html": true}], "postprocess": true}
+//
+//  -> {"method": "call", "function": "catalogURL", "args": ["Builder"], "context": {...}}
+//  <- {"result": "<a href=\"...\">Builder</a>"}
+//
+//  -> {"method": "postprocess", "output": "world/human.html", "content": "..."}
+//  <- {"content": "..."}
+//
+// Any response may instead be {"error": "message"}. The helper is stopped by
+// closing its stdin.
+type helper struct {
+	cmd *exec.Cmd
+	enc *json.Encoder
+	dec *json.Decoder
+	in  io.Closer
+
+	// mu guards the request / response exchange.
+	mu sync.Mutex
+
+	// desc is the response to the describe request.
+	desc helperResponse
+}
+
+// helperFunc describes a single template function of a helper.
+type helperFunc struct {
+	// Name is the name of the function in templates.
+	Name string `json:"name"`
+
+	// HTML is whether or not the string result of the function is HTML, which
+	// is not escaped by templates.
+	HTML bool `json:"html,omitempty"`
+}
+
+// helperContext is the context (see tmpl.Context) a function is called in.
+type helperContext struct {
+	File    string            `json:"file,omitempty"`
+	Package string            `json:"package,omitempty"`
+	Output  string            `json:"output,omitempty"`
+	Params  map[string]string `json:"params,omitempty"`
+}
+
+// helperRequest is a request sent to a helper.
+type helperRequest struct {
+	Method   string         `json:"method"`
+	Function string         `json:"function,omitempty"`
+	Args     []interface{}  `json:"args,omitempty"`
+	Context  *helperContext `json:"context,omitempty"`
+	Output   string         `json:"output,omitempty"`
+	Content  *string        `json:"content,omitempty"`
+}
+
+// helperResponse is a response received from a helper.
+type helperResponse struct {
+	Error string `json:"error,omitempty"`
+
+	// Describe responses.
+	Functions   []helperFunc `json:"functions,omitempty"`
+	PostProcess bool         `json:"postprocess,omitempty"`
+
+	// Call responses.
+	Result interface{} `json:"result,omitempty"`
+
+	// Postprocess responses.
+	Content *string `json:"content,omitempty"`
+}
+
+// startHelper starts the helper command and asks it to describe itself.
+func startHelper(cmd *exec.Cmd) (*helper, error) {
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	h := &helper{
+		cmd: cmd,
+		enc: json.NewEncoder(in),
+		dec: json.NewDecoder(out),
+		in:  in,
+	}
+	desc, err := h.do(&helperRequest{Method: "describe"})
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("describe: %s", err)
+	}
+	h.desc = *desc
+	return h, nil
+}
+
+// helperCommand returns the command for the "funcs" parameter, a path to the
+// helper executable optionally followed by space-separated arguments.
+func helperCommand(param string) (*exec.Cmd, error) {
+	fields := strings.Fields(param)
+	if len(fields) == 0 {
+		return nil, errors.New("empty funcs command")
+	}
+	return exec.Command(fields[0], fields[1:]...), nil
+}
+
+// do sends the request to the helper and returns its response.
+func (h *helper) do(req *helperRequest) (*helperResponse, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.enc.Encode(req); err != nil {
+		return nil, err
+	}
+	var resp helperResponse
+	if err := h.dec.Decode(&resp); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// funcMap returns the helper's template functions, for use with
+// tmpl.Generator.Funcs.
+func (h *helper) funcMap() template.FuncMap {
+	funcs := make(template.FuncMap, len(h.desc.Functions))
+	for _, fn := range h.desc.Functions {
+		fn := fn
+		funcs[fn.Name] = func(ctx *tmpl.Context, args ...interface{}) (interface{}, error) {
+			resp, err := h.do(&helperRequest{
+				Method:   "call",
+				Function: fn.Name,
+				Args:     args,
+				Context: &helperContext{
+					File:    ctx.File.GetName(),
+					Package: packageName(ctx),
+					Output:  ctx.Output,
+					Params:  ctx.Params,
+				},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", fn.Name, err)
+			}
+			if s, ok := resp.Result.(string); ok && fn.HTML {
+				return template.HTML(s), nil
+			}
+			return resp.Result, nil
+		}
+	}
+	return funcs
+}
+
+// packageName returns the name of the target package of the context, if any.
+func packageName(ctx *tmpl.Context) string {
+	if ctx.Package != nil {
+		return ctx.Package.Name
+	}
+	return ctx.File.GetPackage()
+}
+
+// postProcess post-processes the generated file, for use as
+// tmpl.Generator.PostProcess. Files are left as-is if the helper does not
+// post-process files.
+func (h *helper) postProcess(output, content string) (string, error) {
+	if !h.desc.PostProcess {
+		return content, nil
+	}
+	resp, err := h.do(&helperRequest{
+		Method:  "postprocess",
+		Output:  output,
+		Content: &content,
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Content == nil {
+		return "", errors.New("postprocess response has no content")
+	}
+	return *resp.Content, nil
+}
+
+// Close stops the helper, by closing its stdin and waiting for it to exit.
+func (h *helper) Close() error {
+	h.in.Close()
+	return h.cmd.Wait()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"sourcegraph.com/sourcegraph/prototools/tmpl"
+)
+
+// stubHelperEnv is the environment variable which makes the test binary act as
+// a stub helper (see stubHelper) instead of running the tests.
+const stubHelperEnv = "PROTOC_GEN_DOC_STUB_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(stubHelperEnv) == "1" {
+		stubHelper()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// stubHelper is a helper which provides a "catalogURL" function returning a
+// link, a "fail" function which always fails, and appends a comment to every
+// file it post-processes.
+func stubHelper() {
+	var (
+		enc     = json.NewEncoder(os.Stdout)
+		scanner = bufio.NewScanner(os.Stdin)
+	)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var req helperRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			enc.Encode(helperResponse{Error: err.Error()})
+			continue
+		}
+		var resp helperResponse
+		switch req.Method {
+		case "describe":
+			resp.Functions = []helperFunc{{Name: "catalogURL", HTML: true}, {Name: "fail"}}
+			resp.PostProcess = true
+		case "call":
+			switch req.Function {
+			case "catalogURL":
+				resp.Result = fmt.Sprintf(`<a href="https://catalog/%s/%s">%s</a>`, req.Context.Package, req.Args[0], req.Args[0])
+			default:
+				resp.Error = "no service catalog"
+			}
+		case "postprocess":
+			content := *req.Content + "<!-- " + req.Output + " -->"
+			resp.Content = &content
+		default:
+			resp.Error = "unknown method " + req.Method
+		}
+		enc.Encode(resp)
+	}
+}
+
+func TestHelper(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), stubHelperEnv+"=1")
+	h, err := startHelper(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	g := tmpl.New()
+	err = g.SetRequest(&plugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"a.proto"},
+		ProtoFile: []*descriptor.FileDescriptorProto{{
+			Name:    proto.String("a.proto"),
+			Package: proto.String("pkg"),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.ReadFile = func(path string) ([]byte, error) {
+		switch path {
+		case "ok.html":
+			return []byte(`{{catalogURL "Builder"}}`), nil
+		case "fail.html":
+			return []byte(`{{fail}}`), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	g.Funcs(h.funcMap())
+	g.PostProcess = h.postProcess
+	g.KeepGoing = true
+	err = g.ParseFileMap("", `<FileMap>
+    <Generate><Template>ok.html</Template><Target>a.proto</Target><Output>ok.html</Output></Generate>
+    <Generate><Template>fail.html</Template><Target>a.proto</Target><Output>fail.html</Output></Generate>
+</FileMap>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.File) != 1 {
+		t.Fatalf("got %d files want 1", len(resp.File))
+	}
+	want := `<a href="https://catalog/pkg/Builder">Builder</a><!-- ok.html -->`
+	if got := resp.File[0].GetContent(); got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if errs := g.Errors(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "fail: no service catalog") {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
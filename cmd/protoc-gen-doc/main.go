@@ -1,4 +1,6 @@
 // Command protoc-gen-doc is a Protobuf plugin for documentation generation.
+// Given arguments, it instead runs standalone on a request saved by
+// protoc-gen-dump or protoc-gen-json (run with -h for usage).
 //
 // Documentation can be found inside:
 //
@@ -12,7 +14,7 @@ package main // import "sourcegraph.com/sourcegraph/prototools/cmd/protoc-gen-do
 
 import (
 	"bytes"
-	"encoding/xml"
+	"encoding/json"
 	"fmt"
 	"go/build"
 	"io"
@@ -20,6 +22,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
@@ -41,16 +44,39 @@ func PathDir(relPath string) string {
 	return relPath
 }
 
-// extendParams extends the given parameter map with the second one.
-func extendParams(params, second map[string]string) map[string]string {
-	for k, v := range second {
-		if _, ok := params[k]; !ok {
-			params[k] = v
-		}
-	}
-	return params
+// paramSchema declares the plugin parameters. Other parameters are passed on
+// to templates (see the param template function) with a warning, unless they
+// look like misspellings of these.
+var paramSchema = util.ParamSchema{
+	{Name: "conf"},
+	{Name: "template"},
+	{Name: "filemap"},
+	{Name: "layout"},
+	{Name: "format"},
+	{Name: "root"},
+	{Name: "search-path", Repeated: true},
+	{Name: "dump-filemap"},
+	{Name: "funcs"},
+	{Name: "apihost"},
+	{Name: "apihost.*"},
+	{Name: "apihost-*"},
+	{Name: "exclude", Type: util.ListParam},
+	{Name: "exclude-option"},
+	{Name: "exclude-tag"},
+	{Name: "link.*"},
+	{Name: "data.*"},
+	{Name: "openapi-title"},
+	{Name: "openapi-version"},
+	{Name: "error-format"},
+	{Name: "error-report"},
+	{Name: "keep-going", Type: util.BoolParam},
+	{Name: "error-placeholders", Type: util.BoolParam},
+	{Name: "strict", Type: util.BoolParam},
 }
 
+// basicFileMap is the filemap used for the "file" layout, it executes a
+// single template once on each input proto file. It must be formatted with
+// the template path and the includes XML.
 var basicFileMap = `
 <FileMap>
 {{$templatePath := "%s"}}
@@ -59,18 +85,53 @@ var basicFileMap = `
         <Template>{{$templatePath}}</Template>
         <Target>{{.Name}}</Target>
         <Output>{{trimExt .Name}}{{ext $templatePath}}</Output>
+        %s
+    </Generate>
+{{end}}
+</FileMap>
+`
+
+// packageFileMap is the filemap used for the "package" layout, it executes a
+// single template once on each protobuf package. It must be formatted with
+// the template path and the includes XML.
+var packageFileMap = `
+<FileMap>
+{{$templatePath := "%s"}}
+{{range packages .ProtoFile}}
+    <Generate>
+        <Template>{{$templatePath}}</Template>
+        <Package>{{.Name}}</Package>
+        <Output>{{packagePath .Name}}{{ext $templatePath}}</Output>
+        %s
     </Generate>
 {{end}}
 </FileMap>
 `
 
+// defaultIncludes is the includes XML used alongside the default templates.
+const defaultIncludes = "<Includes><Include>common.html</Include></Includes>"
+
+// openAPIFileMap is the filemap used for the "openapi" format, it executes the
+// built-in OpenAPI template once for all of the input proto files.
+const openAPIFileMap = `
+<FileMap>
+    <Generate>
+        <Template>openapi.json</Template>
+        <Output>openapi.json</Output>
+    </Generate>
+</FileMap>
+`
+
 func main() {
 	// Configure logging.
 	log.SetFlags(0)
 	log.SetPrefix("protoc-gen-doc: ")
 
-	// Create a template generator.
-	g := tmpl.New()
+	// Run standalone if given any arguments, as protoc passes none.
+	if len(os.Args) > 1 {
+		standalone(os.Args[1:])
+		return
+	}
 
 	// Read input from the protoc compiler.
 	data, err := ioutil.ReadAll(os.Stdin)
@@ -83,101 +144,314 @@ func main() {
 	if err := proto.Unmarshal(data, request); err != nil {
 		log.Fatal(err, ": failed to parse input proto")
 	}
-	if err := g.SetRequest(request); err != nil {
-		log.Fatal(err, ": failed to set request")
+
+	// Parse the command-line parameters, and perform generation.
+	params, err := util.ParseParamString(request.GetParameter())
+	if err != nil {
+		log.Fatal(err, ": invalid parameters")
+	}
+	response := generate(request, params)
+
+	// Marshal the results and write back to the protoc compiler.
+	data, err = proto.Marshal(response)
+	if err != nil {
+		log.Fatal(err, ": failed to marshal output proto")
 	}
+	_, err = io.Copy(os.Stdout, bytes.NewReader(data))
+	if err != nil {
+		log.Fatal(err, ": failed to write output proto")
+	}
+}
+
+// generate generates the documentation for the request with the given
+// parameters. Invalid parameters and other setup errors are fatal, while the
+// errors of the templates are returned in the response.
+func generate(request *plugin.CodeGeneratorRequest, params *util.Params) *plugin.CodeGeneratorResponse {
 	if len(request.FileToGenerate) == 0 {
-		log.Fatal(err, ": no input files")
+		log.Fatal("no input files")
 	}
 
-	// Verify the command-line parameters.
-	params := util.ParseParams(request)
+	// Create a template generator. The default filemap may be imported by name
+	// by other filemaps.
+	g := tmpl.New()
+	g.FileMaps = map[string]string{
+		"default": PathDir("src/sourcegraph.com/sourcegraph/prototools/templates/filemap.xml"),
+	}
 
-	// Handle configuration files.
-	if conf, ok := params["conf"]; ok {
+	// Handle configuration files, whose parameters don't override those of the
+	// command line. Structured (YAML or JSON) files may also pass data to the
+	// templates.
+	if conf, ok := params.Lookup("conf"); ok && isConfigFile(conf) {
+		c, err := readConfig(conf)
+		if err != nil {
+			log.Fatal(err, ": invalid conf file")
+		}
+		params.Extend(c.params())
+		g.Data = c.Data
+	} else if ok {
 		confData, err := ioutil.ReadFile(conf)
 		if err != nil {
 			log.Fatal(err, ": could not read conf file")
 		}
-		request.Parameter = proto.String(string(confData))
-		params = extendParams(params, util.ParseParams(request))
+		confParams, err := util.ParseParamString(string(confData))
+		if err != nil {
+			log.Fatal(err, ": invalid conf file")
+		}
+		params.Extend(confParams)
 	}
 
-	paramTemplate, haveTemplate := params["template"]
-	paramFileMap, haveFileMap := params["filemap"]
+	// Verify the parameters. Unknown ones are left for templates with a
+	// warning, unless they are likely misspellings (e.g. "templte").
+	if err := paramSchema.Validate(params); err != nil {
+		var msgs []string
+		for _, e := range err.(util.ParamErrors) {
+			if e.Unknown && e.Suggestion == "" {
+				log.Printf("warning: unknown parameter %s (passed on to templates)", e.Name)
+				continue
+			}
+			msgs = append(msgs, e.Error())
+		}
+		if len(msgs) > 0 {
+			log.Fatalf("invalid parameters:\n%s", strings.Join(msgs, "\n"))
+		}
+	}
+	g.Params = params.Map()
+
+	// Map the external documentation links and the data values given as
+	// parameters, e.g. link.google.protobuf=URL and data.Title=API.
+	for _, k := range params.Keys() {
+		switch {
+		case strings.HasPrefix(k, "link."):
+			if g.Links == nil {
+				g.Links = make(map[string]string)
+			}
+			g.Links[strings.TrimPrefix(k, "link.")] = params.Get(k)
+		case strings.HasPrefix(k, "data."):
+			if g.Data == nil {
+				g.Data = make(map[string]interface{})
+			}
+			g.Data[strings.TrimPrefix(k, "data.")] = params.Get(k)
+		}
+	}
+
+	// Exclude (e.g. internal) elements from the docs, if desired. This must be
+	// done before setting the request.
+	excludeOption, haveOption := params.Lookup("exclude-option")
+	excludeTag, haveTag := params.Lookup("exclude-tag")
+	if params.Has("exclude") || haveOption || haveTag {
+		g.Exclude = &tmpl.Exclude{
+			Option:  excludeOption,
+			Tag:     excludeTag,
+			Symbols: params.List("exclude"),
+		}
+	}
+	if err := g.SetRequest(request); err != nil {
+		log.Fatal(err, ": failed to set request")
+	}
+
+	// Warn about conflicting grpc-gateway HTTP routes.
+	routes, err := g.Routes()
+	if err != nil {
+		log.Fatal(err, ": failed to list routes")
+	}
+	seen := make(map[*tmpl.Route]bool)
+	for _, r := range routes {
+		seen[r] = true
+		for _, c := range r.Conflicts {
+			if !seen[c] {
+				log.Printf("conflicting routes: %s (%s) and %s (%s)", r, r.Method, c, c.Method)
+			}
+		}
+	}
+
+	paramTemplate, haveTemplate := params.Lookup("template")
+	paramFileMap, haveFileMap := params.Lookup("filemap")
 	if haveTemplate && haveFileMap {
 		log.Fatal("expected either template or filemap argument, not both")
 	}
 
+	// Determine the layout of the output files.
+	layoutFileMap, defaultTemplate := basicFileMap, "tmpl.html"
+	switch params.Get("layout") {
+	case "", "file":
+		g.Layout = tmpl.FileLayout
+	case "package":
+		g.Layout = tmpl.PackageLayout
+		layoutFileMap, defaultTemplate = packageFileMap, "package.html"
+	default:
+		log.Fatalf("unknown layout %q (expected file or package)", params.Get("layout"))
+	}
+	if haveFileMap && g.Layout != tmpl.FileLayout {
+		log.Fatal("expected either layout or filemap argument, not both")
+	}
+
+	// Determine the output format, either HTML documentation (per the layout,
+	// template or filemap) or an OpenAPI 3 document for the grpc-gateway
+	// routes.
+	format := params.Get("format")
+	switch format {
+	case "", "html":
+	case "openapi":
+		if haveTemplate || haveFileMap || params.Get("layout") != "" {
+			log.Fatal("expected either format=openapi or template, filemap or layout argument, not both")
+		}
+	default:
+		log.Fatalf("unknown format %q (expected html or openapi)", format)
+	}
+
 	// Build the filemap based on the command-line parameters.
 	var fileMapDir, fileMapData string
-	if haveTemplate {
-		// Use the specified template file once on each input proto file.
-		fileMapData = fmt.Sprintf(basicFileMap, paramTemplate)
-	} else if haveFileMap {
-		// Load the filemap template.
-		data, err := ioutil.ReadFile(paramFileMap)
-		if err != nil {
-			log.Fatal(err, ": failed to read file map")
-		}
-		fileMapData = string(data)
-		fileMapDir = filepath.Dir(paramFileMap)
-	} else {
-		// Use the default filemap template once on each input proto file.
-		def := PathDir("src/sourcegraph.com/sourcegraph/prototools/templates/tmpl.html")
-		fileMapData = fmt.Sprintf(basicFileMap, def)
+	if format == "openapi" {
+		// Use the built-in OpenAPI template.
+		fileMapData = openAPIFileMap
+		fileMapDir = filepath.Dir(PathDir("src/sourcegraph.com/sourcegraph/prototools/templates/openapi.json"))
+	} else if haveTemplate {
+		// Use the specified template file once on each input proto file (or
+		// package).
+		fileMapData = fmt.Sprintf(layoutFileMap, paramTemplate, "")
+	} else if !haveFileMap {
+		// Use the default template once on each input proto file (or package).
+		// Template paths are relative to the filemap directory.
+		def := PathDir("src/sourcegraph.com/sourcegraph/prototools/templates/" + defaultTemplate)
+		fileMapData = fmt.Sprintf(layoutFileMap, defaultTemplate, defaultIncludes)
 		fileMapDir = filepath.Dir(def)
 	}
 
-	// Parse the file map template.
-	if err = g.ParseFileMap(fileMapDir, fileMapData); err != nil {
-		log.Fatal(err, ": failed to parse file map")
+	// Map the API hosts, if any.
+	g.Hosts, err = tmpl.ParseHosts(params.Map())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Add any extra template search paths, which may be repeated or separated
+	// by the OS path list separator (e.g. ':' on Unix).
+	for _, v := range params.Values("search-path") {
+		g.SearchPaths = append(g.SearchPaths, filepath.SplitList(v)...)
 	}
 
-	// Dump the execute filemap template, if desired.
-	if v, ok := params["dump-filemap"]; ok {
-		f, err := os.Create(v)
+	// Determine the root directory.
+	if v, ok := params.Lookup("root"); ok {
+		g.RootDir = v
+	} else {
+		g.RootDir, err = os.Getwd()
 		if err != nil {
-			log.Fatal(err, ": failed to crate dump file")
+			log.Fatal(err)
 		}
-		dump, err := xml.MarshalIndent(g.FileMap, "", "    ")
+	}
+
+	// Determine the format of generator errors.
+	errorFormat := params.Get("error-format")
+	switch errorFormat {
+	case "", "text", "json":
+	default:
+		log.Fatalf("unknown error-format %q (expected text or json)", errorFormat)
+	}
+
+	// Keep the successfully generated files if some generators fail, if
+	// desired. In strict mode the protoc run still fails.
+	// The flags have been validated above.
+	g.KeepGoing, _ = params.Bool("keep-going")
+	g.ErrorReport = params.Get("error-report")
+	g.ErrorPlaceholders, _ = params.Bool("error-placeholders")
+	strict, _ := params.Bool("strict")
+	if (g.ErrorReport != "" || g.ErrorPlaceholders) && !g.KeepGoing {
+		log.Fatal("error-report and error-placeholders require the keep-going argument")
+	}
+
+	// Start the out-of-process helper providing extra template functions and
+	// post-processing, if any. This is done after the parameters have been
+	// validated, and failures from here on go through fatalf, so that the
+	// helper is stopped rather than left running.
+	var h *helper
+	if v, ok := params.Lookup("funcs"); ok {
+		cmd, err := helperCommand(v)
 		if err != nil {
-			log.Fatal(err, ": failed to marshal filemap")
+			log.Fatal(err)
 		}
-		_, err = io.Copy(f, bytes.NewReader(dump))
+		h, err = startHelper(cmd)
 		if err != nil {
-			log.Fatal(err, ": failed to write dump file")
+			log.Fatal(err, ": failed to start funcs helper")
+		}
+		g.Funcs(h.funcMap())
+		g.PostProcess = h.postProcess
+	}
+	fatalf := func(format string, args ...interface{}) {
+		if h != nil {
+			h.Close()
 		}
+		log.Fatalf(format, args...)
 	}
 
-	// Determine the root directory.
-	if v, ok := params["root"]; ok {
-		g.RootDir = v
+	// Parse the file map template, the filemap file format is determined by
+	// its extension.
+	var parseErr error
+	if haveFileMap {
+		parseErr = g.ParseFileMapFile(paramFileMap)
 	} else {
-		g.RootDir, err = os.Getwd()
+		parseErr = g.ParseFileMap(fileMapDir, fileMapData)
+	}
+
+	// Dump the executed filemap template, if desired. This is done even if
+	// parsing failed, as error positions refer to the executed filemap.
+	if v, ok := params.Lookup("dump-filemap"); ok {
+		f, err := os.Create(v)
 		if err != nil {
-			log.Fatal(err)
+			fatalf("%s: failed to crate dump file", err)
+		}
+		_, err = io.Copy(f, bytes.NewReader(g.ExecutedFileMap()))
+		if err != nil {
+			fatalf("%s: failed to write dump file", err)
 		}
 	}
-
-	// Map the API host, if any.
-	if v, ok := params["apihost"]; ok {
-		g.APIHost = v
+	if parseErr != nil {
+		if _, ok := parseErr.(tmpl.FileMapErrors); ok {
+			fatalf("invalid file map:\n%s", parseErr)
+		}
+		fatalf("%s: failed to parse file map", parseErr)
 	}
 
 	// Perform generation.
 	response, err := g.Generate()
 	if err != nil {
-		log.Fatal(err, ": failed to generate")
+		fatalf("%s: failed to generate", err)
 	}
-
-	// Marshal the results and write back to the protoc compiler.
-	data, err = proto.Marshal(response)
-	if err != nil {
-		log.Fatal(err, ": failed to marshal output proto")
+	if h != nil {
+		if err := h.Close(); err != nil {
+			log.Fatal(err, ": funcs helper failed")
+		}
 	}
-	_, err = io.Copy(os.Stdout, bytes.NewReader(data))
-	if err != nil {
-		log.Fatal(err, ": failed to write output proto")
+
+	if errs := g.Errors(); len(errs) > 0 {
+		// In keep-going mode the response has no error, unless in strict mode.
+		fail := !g.KeepGoing || strict
+		if !fail {
+			log.Printf("%d generator(s) failed, kept the other files", len(errs))
+		}
+
+		// Report the errors as JSON (one object per line) on stderr, which
+		// protoc passes through, e.g. for CI annotations. Otherwise they are
+		// reported as text via the response.
+		if errorFormat == "json" {
+			enc := json.NewEncoder(os.Stderr)
+			for _, e := range errs {
+				if err := enc.Encode(e); err != nil {
+					log.Fatal(err, ": failed to write errors")
+				}
+			}
+			if fail {
+				response.Error = proto.String(fmt.Sprintf("%d generator(s) failed", len(errs)))
+			}
+		} else if fail && response.Error == nil {
+			var msgs []string
+			for _, e := range errs {
+				msgs = append(msgs, e.Error())
+			}
+			response.Error = proto.String(strings.Join(msgs, "\n"))
+		} else if !fail {
+			for _, e := range errs {
+				log.Print(e)
+			}
+		}
 	}
+	return response
 }
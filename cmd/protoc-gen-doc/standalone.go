@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+// standaloneUsage is the usage text of the standalone mode.
+const standaloneUsage = `usage: protoc-gen-doc [flags] REQUEST
+
+Generates documentation for a saved protoc request, i.e. the binary output of
+protoc-gen-dump or the JSON output of protoc-gen-json, and writes the files to
+the output directory.
+
+Flags:
+`
+
+// standalone runs protoc-gen-doc outside of protoc with the given command-line
+// arguments, reading the request from a file and writing the generated files
+// to disk.
+func standalone(args []string) {
+	fs := flag.NewFlagSet("protoc-gen-doc", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), standaloneUsage)
+		fs.PrintDefaults()
+	}
+	var (
+		out      = fs.String("out", ".", "output `directory`")
+		param    = fs.String("param", "", "comma-separated plugin `parameters`, as given to protoc (e.g. layout=package,apihost=api.example.com)")
+		template = fs.String("template", "", "template `file`, overriding the template parameter")
+		fileMap  = fs.String("filemap", "", "filemap `file`, overriding the filemap parameter")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	request, err := readRequest(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err, ": failed to read request")
+	}
+
+	// The parameters of the saved request were those of protoc-gen-dump or
+	// protoc-gen-json, so they are replaced.
+	request.Parameter = proto.String(*param)
+	params, err := util.ParseParamString(*param)
+	if err != nil {
+		log.Fatal(err, ": invalid parameters")
+	}
+	if *template != "" {
+		params.Set("template", *template)
+	}
+	if *fileMap != "" {
+		params.Set("filemap", *fileMap)
+	}
+
+	response := generate(request, params)
+	if response.Error != nil {
+		log.Fatal(response.GetError())
+	}
+	if err := writeFiles(*out, response.File); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d file(s) to %s", len(response.File), *out)
+}
+
+// readRequest reads a saved request from the file at the given path, which is
+// either JSON (as written by protoc-gen-json) or a binary protobuf message (as
+// written by protoc-gen-dump).
+//
+// JSON requests don't have the custom options (i.e. extensions) of the
+// descriptors, which encoding/json drops, so a warning is logged for them.
+func readRequest(path string) (*plugin.CodeGeneratorRequest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	request := &plugin.CodeGeneratorRequest{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(data, request); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		log.Printf("warning: %s: JSON requests have no custom options or HTTP rules, use protoc-gen-dump for routes, OpenAPI and options", path)
+		return request, nil
+	}
+	if err := proto.Unmarshal(data, request); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return request, nil
+}
+
+// writeFiles writes the generated files into the directory.
+func writeFiles(dir string, files []*plugin.CodeGeneratorResponse_File) error {
+	for _, f := range files {
+		if f.GetName() == "" || f.InsertionPoint != nil {
+			return fmt.Errorf("unsupported response file %q (insertion points are not supported)", f.GetName())
+		}
+		path := filepath.Join(dir, filepath.FromSlash(f.GetName()))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(f.GetContent()), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
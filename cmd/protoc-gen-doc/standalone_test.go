@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+func TestStandalone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protoc-gen-doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	request := &plugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"lib/shelf.proto"},
+		Parameter:      proto.String("out=request.json"),
+		ProtoFile: []*descriptor.FileDescriptorProto{{
+			Name:    proto.String("lib/shelf.proto"),
+			Package: proto.String("lib"),
+			MessageType: []*descriptor.DescriptorProto{{
+				Name: proto.String("Shelf"),
+				Field: []*descriptor.FieldDescriptorProto{{
+					Name:   proto.String("name"),
+					Number: proto.Int32(1),
+					Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:   descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+				}},
+			}},
+		}},
+	}
+
+	// Requests saved by protoc-gen-json and protoc-gen-dump can be read.
+	jsonData, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpData, err := proto.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"request.json": jsonData, "out.gob": dumpData} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readRequest(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(got, request) {
+			t.Fatalf("%s: got %v want %v", name, got, request)
+		}
+	}
+
+	// The files are generated with the given parameters and written to disk.
+	tmplPath := filepath.Join(dir, "doc.txt")
+	err = ioutil.WriteFile(tmplPath, []byte(`{{range .MessageType}}{{.Name}}:{{range .Field}} {{.Name}} {{fieldType .}}{{end}} ({{param "style"}}){{end}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := util.ParseParamString("style=dark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.Set("template", tmplPath)
+	response := generate(request, params)
+	if response.Error != nil {
+		t.Fatal(response.GetError())
+	}
+	out := filepath.Join(dir, "out")
+	if err := writeFiles(out, response.File); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(out, "lib", "shelf.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Shelf: name string (dark)"; string(data) != want {
+		t.Fatalf("got %q want %q", data, want)
+	}
+}
@@ -3,6 +3,9 @@ module sourcegraph.com/sourcegraph/prototools
 go 1.13
 
 require (
+	github.com/ghodss/yaml v1.0.0
 	github.com/golang/protobuf v1.4.2
 	github.com/grpc-ecosystem/grpc-gateway v1.14.5
+	google.golang.org/genproto v0.0.0-20190927181202-20e1ac93f88c
+	google.golang.org/protobuf v1.23.0
 )
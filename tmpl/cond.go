@@ -0,0 +1,178 @@
+package tmpl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+// condData is the data that the When condition of a generator is evaluated
+// with.
+type condData struct {
+	// File is the target file, or the file the target symbol is declared in.
+	// It is nil for package targets and generators without a target.
+	File *descriptor.FileDescriptorProto
+
+	// Package is the target package, or nil.
+	Package *util.Package
+
+	// Symbol is the target symbol, or nil.
+	Symbol util.ASTNode
+
+	// Files are the files of the target: the target file, the files of the
+	// target package, or the files to generate for generators without a
+	// target.
+	Files []*descriptor.FileDescriptorProto
+
+	Generate *FileMapGenerate
+	Request  *plugin.CodeGeneratorRequest
+}
+
+// params returns g.Params, or the parameters parsed from the request.
+func (g *Generator) params() map[string]string {
+	if g.Params != nil {
+		return g.Params
+	}
+	return util.ParseParams(g.request)
+}
+
+// condTemplate parses the When condition of the generator into a template
+// which executes to "true" if the condition holds. The condition must be a
+// single pipeline, so that it can't close the action it is wrapped in (e.g.
+// "true}}{{template ...") to execute or define anything else.
+func (g *Generator) condTemplate(when string) (*template.Template, error) {
+	t, err := template.New("When").Funcs(g.preload()).Parse("{{if (" + when + ")}}true{{end}}")
+	if err != nil {
+		return nil, condError(err)
+	}
+	if len(t.Templates()) != 1 || !isCondTree(t.Tree.Root) {
+		return nil, fmt.Errorf("%q is not a single pipeline", when)
+	}
+	return t, nil
+}
+
+// isCondTree tells if the parsed condition template is the if action wrapping
+// the condition and nothing else.
+func isCondTree(root *parse.ListNode) bool {
+	if len(root.Nodes) != 1 {
+		return false
+	}
+	n, ok := root.Nodes[0].(*parse.IfNode)
+	if !ok || n.ElseList != nil || len(n.List.Nodes) != 1 {
+		return false
+	}
+	text, ok := n.List.Nodes[0].(*parse.TextNode)
+	return ok && string(text.Text) == "true"
+}
+
+// condPosRe matches the position prefix of template errors, e.g.
+// "template: When:1:5: ", which refers to the wrapped condition.
+var condPosRe = regexp.MustCompile(`^template: When:\d+(:\d+)?: `)
+
+// condError returns the error of parsing or executing a condition template
+// without its position, as it refers to the wrapped condition rather than the
+// filemap.
+func condError(err error) error {
+	return errors.New(condPosRe.ReplaceAllString(err.Error(), ""))
+}
+
+// condData returns the data to evaluate the conditions of the generator with.
+func (g *Generator) condData(gen *FileMapGenerate) (*condData, error) {
+	data := &condData{Generate: gen, Request: g.request}
+	switch {
+	case gen.Target != "":
+		for _, f := range g.request.GetProtoFile() {
+			if f.GetName() == gen.Target {
+				data.File = f
+			}
+		}
+		if data.File == nil {
+			return nil, fmt.Errorf("no input proto file for generator target %q", gen.Target)
+		}
+		data.Files = []*descriptor.FileDescriptorProto{data.File}
+	case gen.Package != "":
+		for _, pkg := range util.Packages(g.request.GetProtoFile()) {
+			if pkg.Name == gen.Package {
+				data.Package = pkg
+			}
+		}
+		if data.Package == nil {
+			return nil, fmt.Errorf("no input proto files for generator package %q", gen.Package)
+		}
+		data.Files = data.Package.File
+	case gen.Symbol != "":
+		symbol, f, _, err := g.lookupSymbol(gen.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		data.Symbol, data.File = symbol, f
+		data.Files = []*descriptor.FileDescriptorProto{f}
+	default:
+		for _, f := range g.request.GetProtoFile() {
+			for _, name := range g.request.GetFileToGenerate() {
+				if f.GetName() == name {
+					data.Files = append(data.Files, f)
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+// cond tells if the conditions of the generator (When, IfServices and IfParam)
+// hold, i.e. whether or not it should be executed.
+func (g *Generator) cond(gen *FileMapGenerate) (bool, error) {
+	if gen.When == "" && !gen.IfServices && gen.IfParam == "" {
+		return true, nil
+	}
+	data, err := g.condData(gen)
+	if err != nil {
+		return false, err
+	}
+	params := g.params()
+
+	// Check that the named parameter is set (to the given value, if any).
+	if gen.IfParam != "" {
+		split := strings.SplitN(gen.IfParam, "=", 2)
+		v, ok := params[strings.TrimSpace(split[0])]
+		if !ok || (len(split) == 2 && v != strings.TrimSpace(split[1])) {
+			return false, nil
+		}
+	}
+
+	// Check that the target has services.
+	if gen.IfServices {
+		var services int
+		for _, f := range data.Files {
+			services += len(f.Service)
+		}
+		if services == 0 {
+			return false, nil
+		}
+	}
+
+	// Evaluate the When condition.
+	if gen.When != "" {
+		t, err := g.condTemplate(gen.When)
+		if err != nil {
+			return false, fmt.Errorf("bad condition: %s", err)
+		}
+		ctx := g.newFuncs(gen, data.File, data.Package)
+		buf := bytes.NewBuffer(nil)
+		if err := t.Funcs(ctx.funcMap()).Execute(buf, data); err != nil {
+			return false, fmt.Errorf("bad condition: %s", condError(err))
+		}
+		if buf.String() != "true" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
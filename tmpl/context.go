@@ -0,0 +1,158 @@
+package tmpl
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+// Context is the context that a template is executed in. Custom template
+// functions (see Generator.Funcs) whose first parameter is a *Context are
+// passed it, while templates only pass the remaining arguments, e.g. for:
+//
+//  func catalogURL(ctx *tmpl.Context, service string) string
+//
+// a template calls {{catalogURL "Builder"}}.
+type Context struct {
+	// File is the target proto file, or the file declaring the target symbol.
+	// It is nil for package targets, generators without a target, and while
+	// executing the filemap itself.
+	File *descriptor.FileDescriptorProto
+
+	// Package is the target package, or nil.
+	Package *util.Package
+
+	// Output is the output file of the generator, or an empty string while
+	// executing the filemap itself.
+	Output string
+
+	// Generate is the generator being executed, or nil while executing the
+	// filemap itself.
+	Generate *FileMapGenerate
+
+	// Resolver resolves symbols in all of the proto files of the request.
+	Resolver *util.Resolver
+
+	// Request is the request being generated for.
+	Request *plugin.CodeGeneratorRequest
+
+	// Params are the plugin parameters (see Generator.Params).
+	Params map[string]string
+}
+
+// contextType is the reflect type of *Context.
+var contextType = reflect.TypeOf((*Context)(nil))
+
+// Funcs adds the functions in the map to the function map of the filemap and
+// of every template, overriding built-in functions of the same name. It must be
+// called before parsing the filemap, and returns the generator so that calls
+// may be chained.
+//
+// Functions whose first parameter is a *Context are passed the context that
+// the template is executed in (see Context). It panics if a value in the map is
+// not a function.
+func (g *Generator) Funcs(funcMap template.FuncMap) *Generator {
+	if g.funcs == nil {
+		g.funcs = make(template.FuncMap, len(funcMap))
+	}
+	for name, fn := range funcMap {
+		if v := reflect.ValueOf(fn); v.Kind() != reflect.Func {
+			panic(fmt.Sprintf("tmpl: value for %q is not a function", name))
+		}
+		g.funcs[name] = fn
+	}
+	return g
+}
+
+// bindFuncs returns the custom functions with those taking a *Context bound to
+// the given context.
+func bindFuncs(funcs template.FuncMap, ctx *Context) template.FuncMap {
+	bound := make(template.FuncMap, len(funcs))
+	for name, fn := range funcs {
+		v := reflect.ValueOf(fn)
+		t := v.Type()
+		if t.NumIn() == 0 || t.In(0) != contextType {
+			bound[name] = fn
+			continue
+		}
+
+		// Build the function type without the context parameter.
+		in := make([]reflect.Type, 0, t.NumIn()-1)
+		for i := 1; i < t.NumIn(); i++ {
+			in = append(in, t.In(i))
+		}
+		out := make([]reflect.Type, 0, t.NumOut())
+		for i := 0; i < t.NumOut(); i++ {
+			out = append(out, t.Out(i))
+		}
+		ctxValue := reflect.ValueOf(ctx)
+		wrapper := reflect.MakeFunc(reflect.FuncOf(in, out, t.IsVariadic()), func(args []reflect.Value) []reflect.Value {
+			args = append([]reflect.Value{ctxValue}, args...)
+			if t.IsVariadic() {
+				return v.CallSlice(args)
+			}
+			return v.Call(args)
+		})
+		bound[name] = wrapper.Interface()
+	}
+	return bound
+}
+
+// context returns the context that templates are executed in with these
+// functions.
+func (f *tmplFuncs) context() *Context {
+	return &Context{
+		File:     f.f,
+		Package:  f.pkg,
+		Output:   f.outputFile,
+		Generate: f.gen,
+		Resolver: f.symbolResolver(),
+		Request:  f.request,
+		Params:   f.params,
+	}
+}
+
+// symbolResolver returns the symbol resolver for protoFile, which is created
+// once (or shared with the generator, see Generator.symbolResolver).
+func (f *tmplFuncs) symbolResolver() *util.Resolver {
+	if f.resolver == nil {
+		f.resolver = util.NewResolver(f.protoFile)
+	}
+	return f.resolver
+}
+
+// preload returns the function map to parse templates (and the filemap) with,
+// i.e. Preload along with the custom functions (see Funcs).
+func (g *Generator) preload() template.FuncMap {
+	return g.newFuncs(nil, nil, nil).funcMap()
+}
+
+// newFuncs returns the template functions of the generator for executing the
+// filemap generator gen (or nil, e.g. for the filemap itself) on the file or
+// package (either of which may be nil).
+func (g *Generator) newFuncs(gen *FileMapGenerate, f *descriptor.FileDescriptorProto, pkg *util.Package) *tmplFuncs {
+	ctx := &tmplFuncs{
+		f:         f,
+		pkg:       pkg,
+		rootDir:   g.RootDir,
+		protoFile: g.request.GetProtoFile(),
+		registry:  g.registry,
+		resolver:  g.symbolResolver(),
+		apiHost:   g.apiHost(),
+		apiHosts:  g.Hosts,
+		links:     g.Links,
+		layout:    g.Layout,
+		params:    g.params(),
+		request:   g.request,
+		gen:       gen,
+		funcs:     g.funcs,
+	}
+	if gen != nil {
+		ctx.outputFile = gen.Output
+	}
+	return ctx
+}
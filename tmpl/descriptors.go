@@ -0,0 +1,270 @@
+package tmpl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+// Group is a group of files or symbols, as returned by the groupByPackage and
+// groupByFile template functions.
+type Group struct {
+	// Key is the name of the package or file.
+	Key string
+
+	// Items are the files or symbols in the group, in their original order.
+	Items []interface{}
+}
+
+// descriptorFuncs are the template functions for sorting, filtering and
+// grouping lists of descriptors (e.g. .MessageType or .Field), see funcMap
+// (which adds withOption and withoutOption).
+var descriptorFuncs = map[string]interface{}{
+	"symbols":        symbols,
+	"sortByName":     sortByName,
+	"sortByNumber":   sortByNumber,
+	"deprecated":     func(list interface{}) (interface{}, error) { return filter(list, isDeprecated) },
+	"notDeprecated":  func(list interface{}) (interface{}, error) { return filter(list, not(isDeprecated)) },
+	"filterLabel":    filterLabel,
+	"filterType":     filterType,
+	"groupByPackage": func(list interface{}) ([]*Group, error) { return groupBy(list, itemPackage) },
+	"groupByFile":    func(list interface{}) ([]*Group, error) { return groupBy(list, itemFile) },
+}
+
+// symbols returns all of the files, packages, services, messages (including
+// nested ones, named e.g. "Outer.Inner") or enums (kind is "file", "package",
+// "service", "message" or "enum") declared in the given files (or lists of
+// files).
+func symbols(kind string, files ...interface{}) ([]*Symbol, error) {
+	var all []*descriptor.FileDescriptorProto
+	for _, f := range files {
+		switch t := f.(type) {
+		case *descriptor.FileDescriptorProto:
+			all = append(all, t)
+		case []*descriptor.FileDescriptorProto:
+			all = append(all, t...)
+		default:
+			return nil, fmt.Errorf("expected a file or a list of files, got %T", f)
+		}
+	}
+	return forEachItems(kind, all)
+}
+
+// listValue returns the reflect value of the list, which must be a slice.
+func listValue(list interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice {
+		return reflect.Value{}, fmt.Errorf("expected a list, got %T", list)
+	}
+	return v, nil
+}
+
+// sortBy returns a sorted copy of the list (of the same type), using the
+// given less function on its elements.
+func sortBy(list interface{}, less func(a, b interface{}) bool) (interface{}, error) {
+	v, err := listValue(list)
+	if err != nil {
+		return nil, err
+	}
+	sorted := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(sorted, v)
+	sort.SliceStable(sorted.Interface(), func(i, j int) bool {
+		return less(sorted.Index(i).Interface(), sorted.Index(j).Interface())
+	})
+	return sorted.Interface(), nil
+}
+
+// sortByName returns a copy of the list of descriptors (or symbols) sorted by
+// their name.
+func sortByName(list interface{}) (interface{}, error) {
+	return sortBy(list, func(a, b interface{}) bool {
+		return itemName(a) < itemName(b)
+	})
+}
+
+// sortByNumber returns a copy of the list of fields (or enum values) sorted by
+// their number.
+func sortByNumber(list interface{}) (interface{}, error) {
+	return sortBy(list, func(a, b interface{}) bool {
+		return itemNumber(a) < itemNumber(b)
+	})
+}
+
+// filter returns a copy of the list (of the same type) with only the elements
+// that keep returns true for.
+func filter(list interface{}, keep func(v interface{}) bool) (interface{}, error) {
+	v, err := listValue(list)
+	if err != nil {
+		return nil, err
+	}
+	out := reflect.MakeSlice(v.Type(), 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if keep(v.Index(i).Interface()) {
+			out = reflect.Append(out, v.Index(i))
+		}
+	}
+	return out.Interface(), nil
+}
+
+// not returns the negation of the predicate.
+func not(fn func(v interface{}) bool) func(v interface{}) bool {
+	return func(v interface{}) bool { return !fn(v) }
+}
+
+// isDeprecated tells if the descriptor (or symbol) has the deprecated option.
+func isDeprecated(v interface{}) bool {
+	return optionSet(itemOptions(v), "deprecated")
+}
+
+// withOption returns the descriptors (or symbols) in the list which have the
+// named option set, e.g. "deprecated" or a custom option such as
+// "(google.api.http)" (see option).
+func (f *tmplFuncs) withOption(name string, list interface{}) (interface{}, error) {
+	return f.filterOption(name, list, true)
+}
+
+// withoutOption returns the descriptors (or symbols) in the list which don't
+// have the named option set.
+func (f *tmplFuncs) withoutOption(name string, list interface{}) (interface{}, error) {
+	return f.filterOption(name, list, false)
+}
+
+// filterOption returns the descriptors (or symbols) in the list which have
+// the named option set (or unset, if set is false), or the first error of
+// hasOption.
+func (f *tmplFuncs) filterOption(name string, list interface{}, set bool) (interface{}, error) {
+	var err error
+	out, ferr := filter(list, func(v interface{}) bool {
+		ok, herr := f.hasOption(v, name)
+		if herr != nil && err == nil {
+			err = herr
+		}
+		return ok == set
+	})
+	if ferr != nil {
+		return nil, ferr
+	}
+	return out, err
+}
+
+// filterLabel returns the fields in the list with the given label, "optional",
+// "required" or "repeated".
+func filterLabel(label string, list interface{}) (interface{}, error) {
+	var want descriptor.FieldDescriptorProto_Label
+	switch label {
+	case "optional":
+		want = descriptor.FieldDescriptorProto_LABEL_OPTIONAL
+	case "required":
+		want = descriptor.FieldDescriptorProto_LABEL_REQUIRED
+	case "repeated":
+		want = descriptor.FieldDescriptorProto_LABEL_REPEATED
+	default:
+		return nil, fmt.Errorf("unknown label %q (expected optional, required, or repeated)", label)
+	}
+	return filter(list, func(v interface{}) bool {
+		field, ok := v.(*descriptor.FieldDescriptorProto)
+		return ok && field.GetLabel() == want
+	})
+}
+
+// filterType returns the fields in the list of the given type, either a
+// protobuf type name (e.g. "string", "message" or "enum"), or the
+// fully-qualified name of a message or enum type (e.g. ".pkg.Message").
+func filterType(typ string, list interface{}) (interface{}, error) {
+	return filter(list, func(v interface{}) bool {
+		field, ok := v.(*descriptor.FieldDescriptorProto)
+		if !ok {
+			return false
+		}
+		if field.GetTypeName() == typ {
+			return true
+		}
+		return field.Type != nil && util.FieldTypeName(field.Type) == typ
+	})
+}
+
+// groupBy groups the files or symbols in the list by the given key, returning
+// the groups sorted by key.
+func groupBy(list interface{}, key func(v interface{}) string) ([]*Group, error) {
+	v, err := listValue(list)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		groups []*Group
+		byKey  = make(map[string]*Group)
+	)
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i).Interface()
+		k := key(item)
+		g, ok := byKey[k]
+		if !ok {
+			g = &Group{Key: k}
+			byKey[k] = g
+			groups = append(groups, g)
+		}
+		g.Items = append(g.Items, item)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Key < groups[j].Key
+	})
+	return groups, nil
+}
+
+// itemName returns the name of the descriptor or symbol.
+func itemName(v interface{}) string {
+	switch t := v.(type) {
+	case *Symbol:
+		return t.Name
+	case interface{ GetName() string }:
+		return t.GetName()
+	}
+	return ""
+}
+
+// itemNumber returns the number of the field or enum value.
+func itemNumber(v interface{}) int32 {
+	if n, ok := v.(interface{ GetNumber() int32 }); ok {
+		return n.GetNumber()
+	}
+	return 0
+}
+
+// itemPackage returns the package name of the file or symbol.
+func itemPackage(v interface{}) string {
+	switch t := v.(type) {
+	case *Symbol:
+		return t.Package
+	case *descriptor.FileDescriptorProto:
+		return util.PackageName(t)
+	}
+	return ""
+}
+
+// itemFile returns the file name of the file or symbol.
+func itemFile(v interface{}) string {
+	switch t := v.(type) {
+	case *Symbol:
+		return t.File.GetName()
+	case *descriptor.FileDescriptorProto:
+		return t.GetName()
+	}
+	return ""
+}
+
+// itemOptions returns the options message of the descriptor or symbol, or nil.
+func itemOptions(v interface{}) proto.Message {
+	if item, ok := v.(*Symbol); ok {
+		return item.options
+	}
+	m := reflect.ValueOf(v).MethodByName("GetOptions")
+	if !m.IsValid() || m.Type().NumIn() != 0 || m.Type().NumOut() != 1 {
+		return nil
+	}
+	options, _ := m.Call(nil)[0].Interface().(proto.Message)
+	return options
+}
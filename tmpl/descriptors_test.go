@@ -0,0 +1,74 @@
+package tmpl
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func TestDescriptorFuncs(t *testing.T) {
+	field := func(name string, number int32, label descriptor.FieldDescriptorProto_Label, typ descriptor.FieldDescriptorProto_Type, deprecated bool) *descriptor.FieldDescriptorProto {
+		f := &descriptor.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  label.Enum(),
+			Type:   typ.Enum(),
+		}
+		if deprecated {
+			f.Options = &descriptor.FieldOptions{Deprecated: proto.Bool(true)}
+		}
+		return f
+	}
+	var (
+		optional = descriptor.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptor.FieldDescriptorProto_LABEL_REPEATED
+		str      = descriptor.FieldDescriptorProto_TYPE_STRING
+		i32      = descriptor.FieldDescriptorProto_TYPE_INT32
+	)
+	msg := &descriptor.DescriptorProto{
+		Name: proto.String("Building"),
+		Field: []*descriptor.FieldDescriptorProto{
+			field("name", 3, optional, str, false),
+			field("floors", 1, repeated, i32, false),
+			field("address", 2, optional, str, true),
+		},
+	}
+	files := testRequest().ProtoFile
+
+	var tests = map[string]string{
+		`{{range sortByName .Msg.Field}}{{.GetName}} {{end}}`:                           "address floors name ",
+		`{{range sortByNumber .Msg.Field}}{{.GetName}} {{end}}`:                         "floors address name ",
+		`{{range .Msg.Field | notDeprecated}}{{.GetName}} {{end}}`:                      "name floors ",
+		`{{range .Msg.Field | deprecated}}{{.GetName}} {{end}}`:                         "address ",
+		`{{range .Msg.Field | withOption "deprecated"}}{{.GetName}} {{end}}`:            "address ",
+		`{{range .Msg.Field | withoutOption "deprecated"}}{{.GetName}} {{end}}`:         "name floors ",
+		`{{range .Msg.Field | filterLabel "repeated"}}{{.GetName}} {{end}}`:             "floors ",
+		`{{range .Msg.Field | filterType "string" | sortByNumber}}{{.GetName}} {{end}}`: "address name ",
+		`{{range symbols "message" .Files | sortByName}}{{.FullName}} {{end}}`:          ".world.Building .world.Building.Floor .world.Human ",
+		`{{range symbols "service" .Files}}{{.Descriptor.GetName}} {{end}}`:             "Builder ",
+		`{{range .Files | groupByPackage}}{{.Key}}:{{len .Items}} {{end}}`:              "other:1 world:2 ",
+		`{{range symbols "enum" .Files | groupByFile}}{{.Key}}:{{len .Items}} {{end}}`:  "world/building.proto:1 ",
+	}
+	data := map[string]interface{}{"Msg": msg, "Files": files}
+	for tmpl, want := range tests {
+		tp, err := template.New("").Funcs(Preload).Parse(tmpl)
+		if err != nil {
+			t.Fatalf("%s: %s", tmpl, err)
+		}
+		var buf bytes.Buffer
+		if err := tp.Execute(&buf, data); err != nil {
+			t.Fatalf("%s: %s", tmpl, err)
+		}
+		if got := buf.String(); got != want {
+			t.Fatalf("%s: got %q want %q", tmpl, got, want)
+		}
+	}
+
+	// The original list is left as-is.
+	if got := msg.Field[0].GetName(); got != "name" {
+		t.Fatalf("list was sorted in place, first field is %q", got)
+	}
+}
@@ -0,0 +1,310 @@
+package tmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"github.com/golang/protobuf/proto"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+)
+
+// GenerateError is an error that occurred while executing a single generator,
+// with the context it was executed in.
+type GenerateError struct {
+	// Output is the output file of the generator.
+	Output string `json:"output"`
+
+	// Template is the path of the template file, and Includes the paths of the
+	// included template files, as far as they could be resolved.
+	Template string   `json:"template,omitempty"`
+	Includes []string `json:"includes,omitempty"`
+
+	// Target, Package and Symbol are the target proto file, package, or
+	// fully-qualified symbol of the generator, if any. They identify the page
+	// being generated, not the element of it that the template failed on.
+	Target  string `json:"target,omitempty"`
+	Package string `json:"package,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+
+	// Chain is the chain of {{template}} invocations from the generator's
+	// template to the template that failed (the last one), as far as it could
+	// be determined, e.g. page.html and then "Header" of common.html.
+	Chain []TemplateCall `json:"chain,omitempty"`
+
+	// File, Line and Column are the position inside the template (or include)
+	// file that the error occurred at, if known. Column is zero for template
+	// parse errors.
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+
+	// Err is the underlying error.
+	Err error `json:"-"`
+}
+
+// TemplateCall is a template in the chain of {{template}} invocations of a
+// GenerateError.
+type TemplateCall struct {
+	// Name is the name of the template, i.e. the base name of a template file
+	// or the name of a {{define}} block (e.g. "Header").
+	Name string `json:"name"`
+
+	// File is the path of the template (or include) file that defines it.
+	File string `json:"file,omitempty"`
+}
+
+// Error implements the error interface, e.g.:
+//
+//  world/Builder.html (symbol .world.Builder): templates/common.html:12:5: executing "Header" at <.Foo>: can't evaluate field Foo (via service.html -> Header)
+//
+func (e *GenerateError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Output)
+	switch {
+	case e.Target != "":
+		fmt.Fprintf(&b, " (target %s)", e.Target)
+	case e.Package != "":
+		fmt.Fprintf(&b, " (package %s)", e.Package)
+	case e.Symbol != "":
+		fmt.Fprintf(&b, " (symbol %s)", e.Symbol)
+	}
+	b.WriteString(": ")
+	if e.File != "" {
+		b.WriteString(e.File)
+		if e.Line != 0 {
+			fmt.Fprintf(&b, ":%d", e.Line)
+		}
+		if e.Column != 0 {
+			fmt.Fprintf(&b, ":%d", e.Column)
+		}
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Msg())
+	if len(e.Chain) > 1 {
+		names := make([]string, len(e.Chain))
+		for i, c := range e.Chain {
+			names[i] = c.Name
+		}
+		fmt.Fprintf(&b, " (via %s)", strings.Join(names, " -> "))
+	}
+	return b.String()
+}
+
+// Msg returns the message of the underlying error, without the position prefix
+// that the template packages add (as it is described by File, Line and
+// Column instead).
+func (e *GenerateError) Msg() string {
+	if e.Err == nil {
+		return ""
+	}
+	msg := e.Err.Error()
+	if m := templateErrorRe.FindStringSubmatch(msg); m != nil {
+		return m[4]
+	}
+	return msg
+}
+
+// MarshalJSON implements the json.Marshaler interface, including the error
+// message as "error".
+func (e *GenerateError) MarshalJSON() ([]byte, error) {
+	type plain GenerateError
+	return json.Marshal(struct {
+		*plain
+		Error string `json:"error"`
+	}{(*plain)(e), e.Msg()})
+}
+
+// templateErrorRe matches the position prefix of text/template and
+// html/template errors, e.g.:
+//
+//  template: service.html:12:5: executing "service.html" at <.Foo>: ...
+//  template: service.html:12: function "foo" not defined
+//  html/template:service.html:12:5: ...
+//
+var templateErrorRe = regexp.MustCompile(`^(?:html/)?template: ?([^:]+):(\d+):(?:(\d+):)? (?s)(.*)$`)
+
+// executingRe matches the name of the failing template in the message of a
+// template execution error, e.g. `executing "Header" at <.Foo>: ...`.
+var executingRe = regexp.MustCompile(`^executing "([^"]*)"`)
+
+// generateError returns a GenerateError for the error err that occurred while
+// executing the generator.
+func (g *Generator) generateError(gen *FileMapGenerate, err error) *GenerateError {
+	if gerr, ok := err.(*GenerateError); ok {
+		return gerr
+	}
+	e := &GenerateError{
+		Output:  gen.Output,
+		Target:  gen.Target,
+		Package: gen.Package,
+		Symbol:  gen.Symbol,
+		Err:     err,
+	}
+
+	// Resolve the template and include paths (ignoring errors, which are
+	// likely the reason for err).
+	dirs := g.searchDirs(gen)
+	if p, _, err := g.findFile(dirs, gen.Template); err == nil {
+		e.Template = p
+	} else {
+		e.Template = gen.Template
+	}
+	if includes, err := g.includes(gen); err == nil {
+		e.Includes = includes
+	} else {
+		e.Includes = gen.Include
+	}
+
+	// Determine the position inside the template files. Templates are named by
+	// the base name of their file.
+	files := append([]string{e.Template}, e.Includes...)
+	filePath := func(name string) string {
+		for _, p := range files {
+			if path.Base(p) == name {
+				return p
+			}
+		}
+		return name
+	}
+	if m := templateErrorRe.FindStringSubmatch(err.Error()); m != nil {
+		e.File = filePath(m[1])
+		e.Line, _ = strconv.Atoi(m[2])
+		e.Column, _ = strconv.Atoi(m[3])
+
+		// Determine the chain of templates leading to the failing one.
+		if n := executingRe.FindStringSubmatch(m[4]); n != nil {
+			if t, err := g.prepare(gen); err == nil {
+				trees := make(map[string]*parse.Tree)
+				for _, t := range t.Templates() {
+					if t.Tree != nil {
+						trees[t.Name()] = t.Tree
+					}
+				}
+				for _, name := range templateChain(trees, t.Name(), n[1]) {
+					call := TemplateCall{Name: name}
+					if tree := trees[name]; tree != nil {
+						call.File = filePath(tree.ParseName)
+					}
+					e.Chain = append(e.Chain, call)
+				}
+			}
+		}
+	}
+	return e
+}
+
+// templateChain returns the shortest chain of {{template}} invocations from
+// the template named from to the one named to (both included), or nil if
+// there is none.
+func templateChain(trees map[string]*parse.Tree, from, to string) []string {
+	prev := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == to {
+			var chain []string
+			for ; name != ""; name = prev[name] {
+				chain = append([]string{name}, chain...)
+			}
+			return chain
+		}
+		tree := trees[name]
+		if tree == nil {
+			continue
+		}
+		for _, called := range templateCalls(tree.Root) {
+			if _, ok := prev[called]; !ok {
+				prev[called] = name
+				queue = append(queue, called)
+			}
+		}
+	}
+	return nil
+}
+
+// templateCalls returns the names of the templates invoked by {{template}}
+// actions inside of the node.
+func templateCalls(n parse.Node) []string {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		var names []string
+		for _, c := range n.Nodes {
+			names = append(names, templateCalls(c)...)
+		}
+		return names
+	case *parse.TemplateNode:
+		return []string{n.Name}
+	case *parse.IfNode:
+		return append(templateCalls(n.List), templateCalls(n.ElseList)...)
+	case *parse.RangeNode:
+		return append(templateCalls(n.List), templateCalls(n.ElseList)...)
+	case *parse.WithNode:
+		return append(templateCalls(n.List), templateCalls(n.ElseList)...)
+	}
+	return nil
+}
+
+// reportErrors adds the error report and placeholder files (see
+// g.ErrorReport and g.ErrorPlaceholders) for the errors of the last call to
+// Generate to the response.
+func (g *Generator) reportErrors() error {
+	if g.ErrorPlaceholders {
+		for _, e := range g.errors {
+			g.response.File = append(g.response.File, &plugin.CodeGeneratorResponse_File{
+				Name:    proto.String(e.Output),
+				Content: proto.String(placeholder(e)),
+			})
+		}
+	}
+	if g.ErrorReport == "" {
+		return nil
+	}
+	buf := bytes.NewBuffer(nil)
+	if strings.ToLower(path.Ext(g.ErrorReport)) == ".json" {
+		data, err := json.MarshalIndent(g.errors, "", "  ")
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteString("\n")
+	} else {
+		for _, e := range g.errors {
+			fmt.Fprintf(buf, "%s\n", e)
+		}
+	}
+	g.response.File = append(g.response.File, &plugin.CodeGeneratorResponse_File{
+		Name:    proto.String(g.ErrorReport),
+		Content: proto.String(buf.String()),
+	})
+	return nil
+}
+
+// placeholder returns the contents of a placeholder file describing the error,
+// an HTML page for HTML outputs and plain text otherwise.
+func placeholder(e *GenerateError) string {
+	switch strings.ToLower(path.Ext(e.Output)) {
+	case ".html", ".htm":
+		return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head><title>Error generating %s</title></head>
+<body>
+<h1>Error generating %s</h1>
+<pre>%s</pre>
+</body>
+</html>
+`, html.EscapeString(e.Output), html.EscapeString(e.Output), html.EscapeString(e.Error()))
+	default:
+		return fmt.Sprintf("error generating %s:\n%s\n", e.Output, e)
+	}
+}
@@ -0,0 +1,361 @@
+package tmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// Example is a sample HTTP request of a route, as rendered by the curlExample
+// and httpExample template functions.
+type Example struct {
+	// Verb is the HTTP method, e.g. "POST".
+	Verb string
+
+	// Host is the host the request is sent to, or nil if there is no API host.
+	Host *Host
+
+	// URL is the full URL of the request, i.e. the URL of the host followed by
+	// the path and query.
+	URL string
+
+	// Path is the path of the request with sample values for its variables,
+	// e.g. "/v1/shelves/1/books".
+	Path string
+
+	// Query is the encoded query string (without a leading "?"), or empty if
+	// there are no query parameters.
+	Query string
+
+	// Body is the indented JSON request body, or empty if there is none.
+	Body string
+}
+
+// example returns the sample request of the *Route or *gateway.Binding. Path
+// variables are filled in by sample values (e.g. "shelves/1" for
+// "{name=shelves/*}"), the top-level scalar fields of the input message which
+// are not bound by the path or body are query parameters, and the body has a
+// sample value for every field of the input message or body field (only the
+// first of each oneof).
+//
+// The request is sent to the host of the environment, if given, and otherwise
+// to the default host (see the host template function). Without either, the
+// URL is just the path and query.
+func (f *tmplFuncs) example(v interface{}, env ...string) (*Example, error) {
+	b, err := binding(v)
+	if err != nil {
+		return nil, err
+	}
+	var host *Host
+	if len(env) > 1 {
+		return nil, fmt.Errorf("expected at most one environment, got %d", len(env))
+	} else if len(env) == 1 || f.apiHost != "" || defaultHost(f.apiHosts) != nil {
+		if host, err = f.host(strings.Join(env, "")); err != nil {
+			return nil, err
+		}
+	}
+	s := &sampler{f: f, b: &openAPIBuilder{f: f}, seen: make(map[string]bool)}
+	input := b.Method.GetInputType()
+	msg, ok := f.symbol(input).(*descriptor.DescriptorProto)
+	if !ok {
+		return nil, fmt.Errorf("unknown input type %s", input)
+	}
+	e := &Example{Verb: b.HTTPMethod, Host: host}
+
+	// The path, with its variables replaced by sample values.
+	bound := make(map[string]bool)
+	for _, p := range splitPathTemplate(b.PathTmpl.Template) {
+		if !p.variable {
+			e.Path += p.literal
+			continue
+		}
+		bound[p.fieldPath] = true
+		e.Path += s.pathValue(input, p.fieldPath, p.pattern)
+	}
+
+	// The body, and the query parameters.
+	var body interface{}
+	if b.Body != nil {
+		if len(b.Body.FieldPath) == 0 {
+			body = s.message(msg, bound)
+		} else {
+			fieldPath := b.Body.FieldPath.String()
+			bound[fieldPath] = true
+			fd := s.b.fieldByPath(input, fieldPath)
+			if fd == nil {
+				return nil, fmt.Errorf("unknown body field %q", fieldPath)
+			}
+			body = s.field(fd)
+		}
+	}
+	if b.Body == nil || len(b.Body.FieldPath) > 0 {
+		var query []string
+		oneofs := make(map[int32]bool)
+		for _, fd := range msg.Field {
+			if bound[fd.GetName()] || s.b.mapEntry(fd) != nil ||
+				fd.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE ||
+				fd.GetType() == descriptor.FieldDescriptorProto_TYPE_GROUP {
+				continue
+			}
+			if fd.OneofIndex != nil {
+				if oneofs[fd.GetOneofIndex()] {
+					continue
+				}
+				oneofs[fd.GetOneofIndex()] = true
+			}
+			query = append(query, url.QueryEscape(jsonName(fd))+"="+url.QueryEscape(fmt.Sprint(s.scalar(fd))))
+		}
+		e.Query = strings.Join(query, "&")
+	}
+	if body != nil {
+		data, err := json.MarshalIndent(body, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		e.Body = string(data)
+	}
+
+	e.URL = e.Path
+	if host != nil {
+		e.URL = host.Endpoint(e.Path)
+	}
+	if e.Query != "" {
+		e.URL += "?" + e.Query
+	}
+	return e, nil
+}
+
+// curlExample returns a curl command for the sample request of the *Route or
+// *gateway.Binding, sent to the host of the optional environment (see example),
+// e.g.:
+//
+//  curl -X POST 'https://api.mysite.com/v1/shelves/1/books' \
+//    -H 'Content-Type: application/json' \
+//    -d '{
+//    "name": "string"
+//  }'
+//
+func (f *tmplFuncs) curlExample(v interface{}, env ...string) (string, error) {
+	e, err := f.example(v, env...)
+	if err != nil {
+		return "", err
+	}
+	cmd := "curl "
+	if e.Verb != "GET" || e.Body != "" {
+		cmd += "-X " + e.Verb + " "
+	}
+	cmd += shellQuote(e.URL)
+	if e.Body != "" {
+		cmd += " \\\n  -H " + shellQuote("Content-Type: application/json")
+		cmd += " \\\n  -d " + shellQuote(e.Body)
+	}
+	return cmd, nil
+}
+
+// httpExample returns the raw HTTP/1.1 text of the sample request of the *Route
+// or *gateway.Binding, sent to the host of the optional environment (see
+// example), e.g.:
+//
+//  POST /v1/shelves/1/books HTTP/1.1
+//  Host: api.mysite.com
+//  Content-Type: application/json
+//
+//  {
+//    "name": "string"
+//  }
+//
+func (f *tmplFuncs) httpExample(v interface{}, env ...string) (string, error) {
+	e, err := f.example(v, env...)
+	if err != nil {
+		return "", err
+	}
+	target, host := e.Path, ""
+	if e.Host != nil {
+		target = e.Host.BasePath + target
+		host = e.Host.Host
+		if e.Host.Port != 0 {
+			host += fmt.Sprintf(":%d", e.Host.Port)
+		}
+	}
+	if e.Query != "" {
+		target += "?" + e.Query
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\n", e.Verb, target)
+	if host != "" {
+		fmt.Fprintf(&buf, "Host: %s\n", host)
+	}
+	if e.Body != "" {
+		fmt.Fprintf(&buf, "Content-Type: application/json\n\n%s\n", e.Body)
+	}
+	return buf.String(), nil
+}
+
+// shellQuote quotes the string for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// jsonObject is a JSON object which keeps the order of its members.
+type jsonObject []jsonMember
+
+// jsonMember is a single member of a jsonObject.
+type jsonMember struct {
+	Name  string
+	Value interface{}
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(m.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// wellKnownSamples are the sample JSON values of the well-known types with
+// special JSON mappings (the wrapper types are sampled as their values).
+var wellKnownSamples = map[string]interface{}{
+	".google.protobuf.Timestamp": "1970-01-01T00:00:00Z",
+	".google.protobuf.Duration":  "0s",
+	".google.protobuf.FieldMask": "",
+	".google.protobuf.Struct":    jsonObject{},
+	".google.protobuf.Value":     nil,
+	".google.protobuf.ListValue": []interface{}{},
+	".google.protobuf.Empty":     jsonObject{},
+	".google.protobuf.Any":       jsonObject{{Name: "@type", Value: ""}},
+}
+
+// sampler builds the sample JSON values of an example request, using the
+// proto3 JSON mapping.
+type sampler struct {
+	f *tmplFuncs
+	b *openAPIBuilder
+
+	// seen are the message types being sampled, to stop at recursive types.
+	seen map[string]bool
+}
+
+// pathValue returns a sample value for the path variable binding the field
+// path of the message type, matching the pattern (if any).
+func (s *sampler) pathValue(msgType, fieldPath, pattern string) string {
+	if pattern != "" {
+		return strings.Replace(strings.Replace(pattern, "**", "*", -1), "*", "1", -1)
+	}
+	fd := s.b.fieldByPath(msgType, fieldPath)
+	if fd == nil {
+		return "1"
+	}
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return fd.GetName()
+	}
+	return fmt.Sprint(s.scalar(fd))
+}
+
+// message returns a sample value of the message, with every field except the
+// excluded ones (by name) and all but the first field of each oneof.
+func (s *sampler) message(msg *descriptor.DescriptorProto, exclude map[string]bool) jsonObject {
+	obj := jsonObject{}
+	oneofs := make(map[int32]bool)
+	for _, fd := range msg.Field {
+		if exclude[fd.GetName()] {
+			continue
+		}
+		if fd.OneofIndex != nil {
+			if oneofs[fd.GetOneofIndex()] {
+				continue
+			}
+			oneofs[fd.GetOneofIndex()] = true
+		}
+		obj = append(obj, jsonMember{Name: jsonName(fd), Value: s.field(fd)})
+	}
+	return obj
+}
+
+// field returns a sample JSON value of the field: a list of one value for
+// repeated fields, and an object with one entry for map fields.
+func (s *sampler) field(fd *descriptor.FieldDescriptorProto) interface{} {
+	if entry := s.b.mapEntry(fd); entry != nil {
+		key := fmt.Sprint(s.value(entry.Field[0]))
+		if entry.Field[0].GetType() == descriptor.FieldDescriptorProto_TYPE_STRING {
+			key = "key"
+		}
+		return jsonObject{{Name: key, Value: s.value(entry.Field[1])}}
+	}
+	v := s.value(fd)
+	if fd.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		return []interface{}{v}
+	}
+	return v
+}
+
+// value returns a sample JSON value of a single value of the field's type.
+func (s *sampler) value(fd *descriptor.FieldDescriptorProto) interface{} {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+	default:
+		return s.scalar(fd)
+	}
+	typeName := fd.GetTypeName()
+	if v, ok := wellKnownSamples[typeName]; ok {
+		return v
+	}
+	msg, ok := s.f.symbol(typeName).(*descriptor.DescriptorProto)
+	if !ok || s.seen[typeName] {
+		return jsonObject{}
+	}
+	if strings.HasPrefix(typeName, ".google.protobuf.") && strings.HasSuffix(typeName, "Value") && len(msg.Field) == 1 {
+		// Wrapper types, e.g. google.protobuf.Int64Value.
+		return s.scalar(msg.Field[0])
+	}
+	s.seen[typeName] = true
+	defer delete(s.seen, typeName)
+	return s.message(msg, nil)
+}
+
+// scalar returns a sample JSON value of the non-message field, e.g. "string"
+// for strings, 0 for numbers (and "0" for 64-bit integers) and the first value
+// of enums.
+func (s *sampler) scalar(fd *descriptor.FieldDescriptorProto) interface{} {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64,
+		descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return "0"
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return false
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return "string"
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return ""
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		if e, ok := s.f.symbol(fd.GetTypeName()).(*descriptor.EnumDescriptorProto); ok && len(e.Value) > 0 {
+			return e.Value[0].GetName()
+		}
+		return 0
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package tmpl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func TestExamples(t *testing.T) {
+	r := routesRequest(t)
+	for _, m := range r.ProtoFile[0].MessageType {
+		switch m.GetName() {
+		case "ListShelvesRequest":
+			m.Field = []*descriptor.FieldDescriptorProto{{
+				Name:   proto.String("page_size"),
+				Number: proto.Int32(1),
+				Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:   descriptor.FieldDescriptorProto_TYPE_INT32.Enum(),
+			}, {
+				Name:   proto.String("filter"),
+				Number: proto.Int32(2),
+				Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:   descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}}
+		case "Book":
+			m.Field = append(m.Field, &descriptor.FieldDescriptorProto{
+				Name:   proto.String("page_count"),
+				Number: proto.Int32(2),
+				Label:  descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+				Type:   descriptor.FieldDescriptorProto_TYPE_INT64.Enum(),
+			})
+		}
+	}
+	g := New()
+	if err := g.SetRequest(r); err != nil {
+		t.Fatal(err)
+	}
+	f := &tmplFuncs{
+		protoFile: r.ProtoFile,
+		registry:  g.registry,
+		request:   g.request,
+		apiHost:   "https://api.example.com/base/",
+	}
+	routes, err := f.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var curl, http []string
+	for _, r := range routes {
+		c, err := f.curlExample(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := f.httpExample(r.Binding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		curl, http = append(curl, c), append(http, h)
+	}
+
+	want := []string{
+		`curl 'https://api.example.com/base/v1/shelves?pageSize=0&filter=string'`,
+		`curl 'https://api.example.com/base/v1/shelves/name'`,
+		`curl 'https://api.example.com/base/v1/shelves/1'`,
+		`curl -X POST 'https://api.example.com/base/v1/shelves/1/books' \
+  -H 'Content-Type: application/json' \
+  -d '{
+  "name": "string",
+  "pageCount": [
+    "0"
+  ]
+}'`,
+		`curl -X POST 'https://api.example.com/base/v2/books' \
+  -H 'Content-Type: application/json' \
+  -d '{
+  "shelf": "string",
+  "book": {
+    "name": "string",
+    "pageCount": [
+      "0"
+    ]
+  }
+}'`,
+	}
+	if got := strings.Join(curl, "\n"); got != strings.Join(want, "\n") {
+		t.Fatalf("got curl examples:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	want = []string{
+		"GET /base/v1/shelves?pageSize=0&filter=string HTTP/1.1\nHost: api.example.com\n",
+		"GET /base/v1/shelves/name HTTP/1.1\nHost: api.example.com\n",
+		"GET /base/v1/shelves/1 HTTP/1.1\nHost: api.example.com\n",
+	}
+	if got := strings.Join(http[:3], ""); got != strings.Join(want, "") {
+		t.Fatalf("got HTTP examples:\n%s\nwant:\n%s", got, strings.Join(want, ""))
+	}
+	if !strings.HasPrefix(http[3], "POST /base/v1/shelves/1/books HTTP/1.1\nHost: api.example.com\nContent-Type: application/json\n\n{\n") {
+		t.Fatalf("got HTTP example:\n%s", http[3])
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got, want := shellQuote(`it's "quoted"`), `'it'\''s "quoted"'`; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+}
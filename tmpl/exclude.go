@@ -0,0 +1,458 @@
+package tmpl
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+)
+
+// Exclude is a policy for excluding (e.g. internal) elements from the
+// generated documentation, see Generator.Exclude.
+//
+// Excluded packages, messages, enums, enum values, fields, services, methods
+// and extensions are removed from the request entirely, as if they were never
+// declared. Fields, methods and extensions whose types are excluded (including
+// maps with excluded value types) are removed along with them, so that no
+// links to excluded types are left.
+type Exclude struct {
+	// Option excludes the elements which have the named custom option set to
+	// a non-false value (e.g. "mycorp.api.internal"), or to the given value
+	// (e.g. "mycorp.api.visibility=INTERNAL"), see the option template
+	// function.
+	Option string
+
+	// Tag excludes the elements whose leading or trailing comments contain
+	// the tag, e.g. "@internal".
+	Tag string
+
+	// Symbols excludes the packages and symbols whose fully-qualified names
+	// (without a leading dot) match any of the glob patterns (see path.Match),
+	// for example:
+	//
+	//  mycorp.internal
+	//  mycorp.api.Account.secret_*
+	//  mycorp.api.Admin.*
+	//
+	Symbols []string
+}
+
+// apply returns a copy of the request with the excluded elements removed.
+func (e *Exclude) apply(r *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorRequest, error) {
+	for _, pattern := range e.Symbols {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("exclude: bad pattern %q", pattern)
+		}
+	}
+	r = proto.Clone(r).(*plugin.CodeGeneratorRequest)
+	x := &excluder{
+		e:        e,
+		f:        &tmplFuncs{protoFile: r.ProtoFile},
+		excluded: make(map[string]bool),
+	}
+
+	// Determine the excluded messages and enums first, so that the fields and
+	// methods referencing them can be removed.
+	excludedFiles := make(map[string]bool)
+	for _, f := range r.ProtoFile {
+		x.locs = locations(f)
+		scope := ""
+		if pkg := f.GetPackage(); pkg != "" {
+			scope = "." + pkg
+		}
+		if f.GetPackage() != "" && x.match(f.GetPackage()) {
+			excludedFiles[f.GetName()] = true
+			x.markMessages(scope, nil, f.MessageType, true)
+			x.markEnums(scope, nil, f.EnumType, true)
+			continue
+		}
+		x.markMessages(scope, []int32{4}, f.MessageType, false)
+		x.markEnums(scope, []int32{5}, f.EnumType, false)
+	}
+
+	// Map entries whose key or value types are excluded are excluded too, so
+	// that their map fields are removed rather than left without values.
+	for _, f := range r.ProtoFile {
+		scope := ""
+		if pkg := f.GetPackage(); pkg != "" {
+			scope = "." + pkg
+		}
+		x.markMapEntries(scope, f.MessageType)
+	}
+	if x.err != nil {
+		return nil, x.err
+	}
+
+	var files []*descriptor.FileDescriptorProto
+	for _, f := range r.ProtoFile {
+		if excludedFiles[f.GetName()] {
+			continue
+		}
+		x.prune(f, excludedFiles)
+		files = append(files, f)
+	}
+	r.ProtoFile = files
+
+	var generate []string
+	for _, name := range r.FileToGenerate {
+		if !excludedFiles[name] {
+			generate = append(generate, name)
+		}
+	}
+	if len(r.FileToGenerate) > 0 && len(generate) == 0 {
+		return nil, fmt.Errorf("exclude: every file to generate is excluded")
+	}
+	r.FileToGenerate = generate
+	return r, x.err
+}
+
+// excluder removes the elements excluded by a policy from the files of a
+// request.
+type excluder struct {
+	e *Exclude
+
+	// f is used to read custom options, see option.
+	f *tmplFuncs
+
+	// excluded is the set of excluded message and enum types, by their
+	// fully-qualified names (e.g. ".pkg.Msg").
+	excluded map[string]bool
+
+	// locs are the source locations of the current file, by path (see
+	// pathKey).
+	locs map[string]*descriptor.SourceCodeInfo_Location
+
+	// remap maps the paths (see pathKey) of the repeated fields of the current
+	// file to maps of their original element indices to their new indices, or
+	// -1 for removed elements.
+	remap map[string]map[int32]int32
+
+	// The first error encountered, e.g. an unknown option.
+	err error
+}
+
+// locations returns the source locations of the file by path (see pathKey).
+func locations(f *descriptor.FileDescriptorProto) map[string]*descriptor.SourceCodeInfo_Location {
+	locs := make(map[string]*descriptor.SourceCodeInfo_Location)
+	for _, loc := range f.GetSourceCodeInfo().GetLocation() {
+		locs[pathKey(loc.Path)] = loc
+	}
+	return locs
+}
+
+// pathKey returns the string key of the source location path, e.g. "4.0.2".
+func pathKey(p []int32) string {
+	s := make([]string, len(p))
+	for i, v := range p {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, ".")
+}
+
+// elem returns the path of the i'th element of the repeated field at the
+// path list (e.g. [4] for the messages of a file), or nil if list is nil.
+func elem(list []int32, i int) []int32 {
+	return field(list, int32(i))
+}
+
+// field returns the path of the field (with the given number) of the element
+// at path p, or nil if p is nil.
+func field(p []int32, number int32) []int32 {
+	if p == nil {
+		return nil
+	}
+	c := make([]int32, 0, len(p)+1)
+	c = append(c, p...)
+	return append(c, number)
+}
+
+// match tells if the fully-qualified name (without a leading dot) matches any
+// of the excluded symbol patterns.
+func (x *excluder) match(name string) bool {
+	for _, pattern := range x.e.Symbols {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// excludes tells if the element with the given fully-qualified name (e.g.
+// ".pkg.Msg"), declared at the source location path p (or nil if unknown), is
+// excluded by the policy.
+func (x *excluder) excludes(name string, node interface{}, p []int32) bool {
+	if x.match(strings.TrimPrefix(name, ".")) {
+		return true
+	}
+	if x.e.Tag != "" && p != nil {
+		if loc := x.locs[pathKey(p)]; loc != nil {
+			comments := loc.GetLeadingComments() + loc.GetTrailingComments()
+			if strings.Contains(comments, x.e.Tag) {
+				return true
+			}
+		}
+	}
+	if x.e.Option != "" {
+		name, want := x.e.Option, ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, want = name[:i], name[i+1:]
+		}
+		v, err := x.f.option(node, name)
+		if err != nil {
+			if x.err == nil {
+				x.err = fmt.Errorf("exclude: %s", err)
+			}
+			return false
+		}
+		if v == nil {
+			return false
+		}
+		if want == "" {
+			return v != false
+		}
+		return fmt.Sprint(v) == want
+	}
+	return false
+}
+
+// markMessages marks the excluded messages declared in the scope at the path
+// list (and all of their nested messages and enums), or all of them if all is
+// true.
+func (x *excluder) markMessages(scope string, list []int32, msgs []*descriptor.DescriptorProto, all bool) {
+	for i, m := range msgs {
+		name := scope + "." + m.GetName()
+		p := elem(list, i)
+		excluded := all || x.excludes(name, m, p)
+		if excluded {
+			x.excluded[name] = true
+		}
+		x.markMessages(name, field(p, 3), m.NestedType, excluded)
+		x.markEnums(name, field(p, 4), m.EnumType, excluded)
+	}
+}
+
+// markMapEntries marks the map entry messages declared in the scope (or
+// nested in its messages) which have a field of an excluded type.
+func (x *excluder) markMapEntries(scope string, msgs []*descriptor.DescriptorProto) {
+	for _, m := range msgs {
+		name := scope + "." + m.GetName()
+		if m.GetOptions().GetMapEntry() {
+			for _, f := range m.Field {
+				if x.excluded[f.GetTypeName()] {
+					x.excluded[name] = true
+				}
+			}
+		}
+		x.markMapEntries(name, m.NestedType)
+	}
+}
+
+// markEnums marks the excluded enums declared in the scope at the path list,
+// or all of them if all is true.
+func (x *excluder) markEnums(scope string, list []int32, enums []*descriptor.EnumDescriptorProto, all bool) {
+	for i, e := range enums {
+		name := scope + "." + e.GetName()
+		if all || x.excludes(name, e, elem(list, i)) {
+			x.excluded[name] = true
+		}
+	}
+}
+
+// keep records the new index of each element of the repeated field at the
+// path list, for which keep reports whether it is kept, and returns the
+// indices of the kept elements.
+func (x *excluder) keep(list []int32, n int, keep func(i int) bool) []int {
+	var (
+		kept  []int
+		remap = make(map[int32]int32, n)
+	)
+	for i := 0; i < n; i++ {
+		if keep(i) {
+			remap[int32(i)] = int32(len(kept))
+			kept = append(kept, i)
+		} else {
+			remap[int32(i)] = -1
+		}
+	}
+	x.remap[pathKey(list)] = remap
+	return kept
+}
+
+// prune removes the excluded elements (and the imports of the given excluded
+// files) from the file, and rewrites the paths of its source locations
+// accordingly.
+func (x *excluder) prune(f *descriptor.FileDescriptorProto, excludedFiles map[string]bool) {
+	x.locs = locations(f)
+	x.remap = make(map[string]map[int32]int32)
+	scope := ""
+	if pkg := f.GetPackage(); pkg != "" {
+		scope = "." + pkg
+	}
+
+	// Drop the imports of excluded files, and renumber the public and weak
+	// imports (which are indices of the imports).
+	var deps []string
+	for _, i := range x.keep([]int32{3}, len(f.Dependency), func(i int) bool {
+		return !excludedFiles[f.Dependency[i]]
+	}) {
+		deps = append(deps, f.Dependency[i])
+	}
+	depRemap := x.remap[pathKey([]int32{3})]
+	f.Dependency = deps
+	f.PublicDependency = x.pruneIndices([]int32{10}, f.PublicDependency, depRemap)
+	f.WeakDependency = x.pruneIndices([]int32{11}, f.WeakDependency, depRemap)
+
+	f.MessageType = x.pruneMessages(scope, []int32{4}, f.MessageType)
+	f.EnumType = x.pruneEnums(scope, []int32{5}, f.EnumType)
+	f.Extension = x.pruneFields(scope, []int32{7}, f.Extension)
+
+	var services []*descriptor.ServiceDescriptorProto
+	for _, i := range x.keep([]int32{6}, len(f.Service), func(i int) bool {
+		return !x.excludes(scope+"."+f.Service[i].GetName(), f.Service[i], elem([]int32{6}, i))
+	}) {
+		s := f.Service[i]
+		services = append(services, s)
+		name, list := scope+"."+s.GetName(), field(elem([]int32{6}, i), 2)
+		var methods []*descriptor.MethodDescriptorProto
+		for _, j := range x.keep(list, len(s.Method), func(j int) bool {
+			m := s.Method[j]
+			return !x.excluded[m.GetInputType()] && !x.excluded[m.GetOutputType()] &&
+				!x.excludes(name+"."+m.GetName(), m, elem(list, j))
+		}) {
+			methods = append(methods, s.Method[j])
+		}
+		s.Method = methods
+	}
+	f.Service = services
+
+	// Rewrite the source locations, dropping those of removed elements.
+	if info := f.GetSourceCodeInfo(); info != nil {
+		var locs []*descriptor.SourceCodeInfo_Location
+		for _, loc := range info.Location {
+			if p, ok := x.rewrite(loc.Path); ok {
+				loc.Path = p
+				locs = append(locs, loc)
+			}
+		}
+		info.Location = locs
+	}
+}
+
+// pruneIndices returns the indices at the path list with the removed ones
+// dropped and the others renumbered, according to remap.
+func (x *excluder) pruneIndices(list []int32, indices []int32, remap map[int32]int32) []int32 {
+	var out []int32
+	for _, i := range x.keep(list, len(indices), func(i int) bool {
+		return remap[indices[i]] >= 0
+	}) {
+		out = append(out, remap[indices[i]])
+	}
+	return out
+}
+
+// pruneMessages returns the messages declared in the scope at the path list
+// which are not excluded, with their excluded contents removed.
+func (x *excluder) pruneMessages(scope string, list []int32, msgs []*descriptor.DescriptorProto) []*descriptor.DescriptorProto {
+	var out []*descriptor.DescriptorProto
+	for _, i := range x.keep(list, len(msgs), func(i int) bool {
+		return !x.excluded[scope+"."+msgs[i].GetName()]
+	}) {
+		m := msgs[i]
+		name, p := scope+"."+m.GetName(), elem(list, i)
+		m.Field = x.pruneFields(name, field(p, 2), m.Field)
+		x.pruneOneofs(field(p, 8), m)
+		m.NestedType = x.pruneMessages(name, field(p, 3), m.NestedType)
+		m.EnumType = x.pruneEnums(name, field(p, 4), m.EnumType)
+		m.Extension = x.pruneFields(name, field(p, 6), m.Extension)
+		out = append(out, m)
+	}
+	return out
+}
+
+// pruneOneofs removes the oneofs of the message (at the path list) which have
+// no fields left, and renumbers the oneof indices of its fields.
+func (x *excluder) pruneOneofs(list []int32, m *descriptor.DescriptorProto) {
+	used := make(map[int32]bool)
+	for _, f := range m.Field {
+		if f.OneofIndex != nil {
+			used[f.GetOneofIndex()] = true
+		}
+	}
+	var oneofs []*descriptor.OneofDescriptorProto
+	for _, i := range x.keep(list, len(m.OneofDecl), func(i int) bool {
+		return used[int32(i)]
+	}) {
+		oneofs = append(oneofs, m.OneofDecl[i])
+	}
+	m.OneofDecl = oneofs
+	remap := x.remap[pathKey(list)]
+	for _, f := range m.Field {
+		if f.OneofIndex != nil {
+			f.OneofIndex = proto.Int32(remap[f.GetOneofIndex()])
+		}
+	}
+}
+
+// pruneEnums returns the enums declared in the scope at the path list which
+// are not excluded, with their excluded values removed.
+func (x *excluder) pruneEnums(scope string, list []int32, enums []*descriptor.EnumDescriptorProto) []*descriptor.EnumDescriptorProto {
+	var out []*descriptor.EnumDescriptorProto
+	for _, i := range x.keep(list, len(enums), func(i int) bool {
+		return !x.excluded[scope+"."+enums[i].GetName()]
+	}) {
+		e := enums[i]
+		name, values := scope+"."+e.GetName(), field(elem(list, i), 2)
+		var kept []*descriptor.EnumValueDescriptorProto
+		for _, j := range x.keep(values, len(e.Value), func(j int) bool {
+			return !x.excludes(name+"."+e.Value[j].GetName(), e.Value[j], elem(values, j))
+		}) {
+			kept = append(kept, e.Value[j])
+		}
+		e.Value = kept
+		out = append(out, e)
+	}
+	return out
+}
+
+// pruneFields returns the fields (or extensions) declared in the scope at the
+// path list which are not excluded and don't reference excluded types.
+func (x *excluder) pruneFields(scope string, list []int32, fields []*descriptor.FieldDescriptorProto) []*descriptor.FieldDescriptorProto {
+	var out []*descriptor.FieldDescriptorProto
+	for _, i := range x.keep(list, len(fields), func(i int) bool {
+		f := fields[i]
+		return !x.excluded[f.GetTypeName()] && !x.excluded[f.GetExtendee()] &&
+			!x.excludes(scope+"."+f.GetName(), f, elem(list, i))
+	}) {
+		out = append(out, fields[i])
+	}
+	return out
+}
+
+// rewrite returns the source location path p with the indices of the kept
+// elements updated, or false if it is the location of a removed element (or
+// is inside of one).
+func (x *excluder) rewrite(p []int32) ([]int32, bool) {
+	out := make([]int32, len(p))
+	copy(out, p)
+	// The paths of repeated fields are the odd-length prefixes of p, e.g. [4]
+	// and [4, 0, 2] for [4, 0, 2, 1].
+	for n := 1; n < len(p); n += 2 {
+		remap, ok := x.remap[pathKey(p[:n])]
+		if !ok {
+			continue
+		}
+		i, ok := remap[p[n]]
+		if !ok {
+			continue
+		}
+		if i < 0 {
+			return nil, false
+		}
+		out[n] = i
+	}
+	return out, true
+}
@@ -0,0 +1,275 @@
+package tmpl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+)
+
+func TestExcludeSymbols(t *testing.T) {
+	r := testRequest()
+	g := New()
+	g.Exclude = &Exclude{Symbols: []string{"other", "world.Human"}}
+	if err := g.SetRequest(r); err != nil {
+		t.Fatal(err)
+	}
+	got := g.request
+	if len(r.ProtoFile) != 3 || len(r.ProtoFile[1].MessageType[0].Field) != 1 {
+		t.Fatal("original request was modified")
+	}
+
+	var files []string
+	for _, f := range got.ProtoFile {
+		files = append(files, f.GetName())
+	}
+	if want := []string{"world/human.proto", "world/building.proto"}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("got files %v want %v", files, want)
+	}
+	human, building := got.ProtoFile[0], got.ProtoFile[1]
+	if len(human.MessageType) != 0 {
+		t.Fatalf("Human was not excluded")
+	}
+	// The owner field and Build method reference the excluded Human message.
+	if n := len(building.MessageType[0].Field); n != 0 {
+		t.Fatalf("got %d Building fields want 0", n)
+	}
+	if n := len(building.Service[0].Method); n != 0 {
+		t.Fatalf("got %d Builder methods want 0", n)
+	}
+}
+
+func TestExcludeTag(t *testing.T) {
+	loc := func(comment string, path ...int32) *descriptor.SourceCodeInfo_Location {
+		return &descriptor.SourceCodeInfo_Location{Path: path, LeadingComments: proto.String(comment)}
+	}
+	f := &descriptor.FileDescriptorProto{
+		Name:    proto.String("a.proto"),
+		Package: proto.String("a"),
+		MessageType: []*descriptor.DescriptorProto{
+			{Name: proto.String("Secret")},
+			{
+				Name: proto.String("Public"),
+				Field: []*descriptor.FieldDescriptorProto{
+					{Name: proto.String("x"), Number: proto.Int32(1)},
+					{Name: proto.String("y"), Number: proto.Int32(2)},
+					{Name: proto.String("secret"), Number: proto.Int32(3), TypeName: proto.String(".a.Secret")},
+					{Name: proto.String("z"), Number: proto.Int32(4)},
+				},
+			},
+		},
+		SourceCodeInfo: &descriptor.SourceCodeInfo{
+			Location: []*descriptor.SourceCodeInfo_Location{
+				loc(" Secret @internal\n", 4, 0),
+				loc(" Public\n", 4, 1),
+				loc(" x\n", 4, 1, 2, 0),
+				loc(" y @internal\n", 4, 1, 2, 1),
+				loc(" secret\n", 4, 1, 2, 2),
+				loc(" z\n", 4, 1, 2, 3),
+			},
+		},
+	}
+	g := New()
+	g.Exclude = &Exclude{Tag: "@internal"}
+	err := g.SetRequest(&plugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"a.proto"},
+		ProtoFile:      []*descriptor.FileDescriptorProto{f},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := g.request.ProtoFile[0]
+	if len(got.MessageType) != 1 || got.MessageType[0].GetName() != "Public" {
+		t.Fatalf("unexpected messages %v", got.MessageType)
+	}
+	var fields []string
+	for _, f := range got.MessageType[0].Field {
+		fields = append(fields, f.GetName())
+	}
+	if want := []string{"x", "z"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("got fields %v want %v", fields, want)
+	}
+
+	// The paths of the remaining locations refer to the new indices.
+	comments := make(map[string]string)
+	for _, l := range got.GetSourceCodeInfo().GetLocation() {
+		comments[pathKey(l.Path)] = strings.TrimSpace(l.GetLeadingComments())
+	}
+	want := map[string]string{"4.0": "Public", "4.0.2.0": "x", "4.0.2.1": "z"}
+	if !reflect.DeepEqual(comments, want) {
+		t.Fatalf("got locations %v want %v", comments, want)
+	}
+}
+
+func TestExcludeMapValue(t *testing.T) {
+	f := &descriptor.FileDescriptorProto{
+		Name:    proto.String("a.proto"),
+		Package: proto.String("a"),
+		MessageType: []*descriptor.DescriptorProto{
+			{Name: proto.String("Secret")},
+			{
+				Name: proto.String("Public"),
+				Field: []*descriptor.FieldDescriptorProto{
+					{
+						Name:     proto.String("m"),
+						Number:   proto.Int32(1),
+						Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+						Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+						TypeName: proto.String(".a.Public.MEntry"),
+					},
+					{Name: proto.String("x"), Number: proto.Int32(2)},
+				},
+				NestedType: []*descriptor.DescriptorProto{{
+					Name: proto.String("MEntry"),
+					Field: []*descriptor.FieldDescriptorProto{
+						{Name: proto.String("key"), Number: proto.Int32(1), Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum()},
+						{Name: proto.String("value"), Number: proto.Int32(2), Type: descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".a.Secret")},
+					},
+					Options: &descriptor.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+		},
+	}
+	g := New()
+	g.Exclude = &Exclude{Symbols: []string{"a.Secret"}}
+	err := g.SetRequest(&plugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"a.proto"},
+		ProtoFile:      []*descriptor.FileDescriptorProto{f},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The map field is removed along with its entry type.
+	public := g.request.ProtoFile[0].MessageType[0]
+	if len(public.Field) != 1 || public.Field[0].GetName() != "x" {
+		t.Fatalf("unexpected fields %v", public.Field)
+	}
+	if len(public.NestedType) != 0 {
+		t.Fatalf("unexpected nested types %v", public.NestedType)
+	}
+}
+
+func TestExcludeOption(t *testing.T) {
+	files := optionsRequestFiles()
+	for _, option := range []string{"mycorp.api.visibility=INTERNAL", "(mycorp.api.limit)"} {
+		g := New()
+		g.Exclude = &Exclude{Option: option}
+		err := g.SetRequest(&plugin.CodeGeneratorRequest{
+			FileToGenerate: []string{"mycorp/svc.proto"},
+			ProtoFile:      files,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := g.request.ProtoFile[1].MessageType[0].Field
+		if len(fields) != 1 || fields[0].GetName() != "id" {
+			t.Fatalf("%s: unexpected fields %v", option, fields)
+		}
+	}
+
+	g := New()
+	g.Exclude = &Exclude{Option: "mycorp.api.visibility=PUBLIC"}
+	if err := g.SetRequest(&plugin.CodeGeneratorRequest{ProtoFile: files}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(g.request.ProtoFile[1].MessageType[0].Field); n != 2 {
+		t.Fatalf("got %d fields want 2", n)
+	}
+}
+
+func TestExcludeErrors(t *testing.T) {
+	for _, e := range []*Exclude{
+		{Symbols: []string{"world.["}},
+		{Option: "mycorp.api.nope"},
+		{Symbols: []string{"world"}}, // Every file to generate.
+	} {
+		g := New()
+		g.Exclude = e
+		if err := g.SetRequest(testRequest()); err == nil {
+			t.Fatalf("%+v: expected error", e)
+		}
+	}
+}
+
+func TestExcludeImportsAndOneofs(t *testing.T) {
+	loc := func(comment string, path ...int32) *descriptor.SourceCodeInfo_Location {
+		return &descriptor.SourceCodeInfo_Location{Path: path, LeadingComments: proto.String(comment)}
+	}
+	field := func(name string, number int32, typeName string, oneof *int32) *descriptor.FieldDescriptorProto {
+		f := &descriptor.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), OneofIndex: oneof}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	a := &descriptor.FileDescriptorProto{
+		Name:             proto.String("a.proto"),
+		Package:          proto.String("a"),
+		Dependency:       []string{"secret.proto", "b.proto"},
+		PublicDependency: []int32{1},
+		WeakDependency:   []int32{0},
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Msg"),
+			Field: []*descriptor.FieldDescriptorProto{
+				field("token", 1, ".secret.Token", proto.Int32(0)),
+				field("x", 2, "", proto.Int32(1)),
+				field("y", 3, "", nil),
+			},
+			OneofDecl: []*descriptor.OneofDescriptorProto{
+				{Name: proto.String("auth")},
+				{Name: proto.String("choice")},
+			},
+		}},
+		SourceCodeInfo: &descriptor.SourceCodeInfo{
+			Location: []*descriptor.SourceCodeInfo_Location{
+				loc(" secret import\n", 3, 0),
+				loc(" b import\n", 3, 1),
+				loc(" public\n", 10, 0),
+				loc(" weak\n", 11, 0),
+				loc(" auth\n", 4, 0, 8, 0),
+				loc(" choice\n", 4, 0, 8, 1),
+			},
+		},
+	}
+	g := New()
+	g.Exclude = &Exclude{Symbols: []string{"secret"}}
+	err := g.SetRequest(&plugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"a.proto"},
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{Name: proto.String("secret.proto"), Package: proto.String("secret"),
+				MessageType: []*descriptor.DescriptorProto{{Name: proto.String("Token")}}},
+			{Name: proto.String("b.proto"), Package: proto.String("b")},
+			a,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := g.request.ProtoFile[1]
+	if !reflect.DeepEqual(got.Dependency, []string{"b.proto"}) {
+		t.Fatalf("got imports %v", got.Dependency)
+	}
+	if !reflect.DeepEqual(got.PublicDependency, []int32{0}) || len(got.WeakDependency) != 0 {
+		t.Fatalf("got public imports %v and weak imports %v", got.PublicDependency, got.WeakDependency)
+	}
+
+	// The auth oneof has no fields left.
+	msg := got.MessageType[0]
+	if len(msg.OneofDecl) != 1 || msg.OneofDecl[0].GetName() != "choice" {
+		t.Fatalf("unexpected oneofs %v", msg.OneofDecl)
+	}
+	if len(msg.Field) != 2 || msg.Field[0].GetOneofIndex() != 0 || msg.Field[1].OneofIndex != nil {
+		t.Fatalf("unexpected fields %v", msg.Field)
+	}
+
+	var comments []string
+	for _, l := range got.SourceCodeInfo.Location {
+		comments = append(comments, pathKey(l.Path)+strings.TrimRight(l.GetLeadingComments(), "\n"))
+	}
+	if want := []string{"3.0 b import", "10.0 public", "4.0.8.0 choice"}; !reflect.DeepEqual(comments, want) {
+		t.Fatalf("got locations %v want %v", comments, want)
+	}
+}
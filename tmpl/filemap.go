@@ -1,37 +1,247 @@
 package tmpl
 
-import "path"
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // FileMapDataItem represents a single pair in a map.
+//
+// By default the value is just the Value string, but the Type attribute may
+// specify another type of value:
+//
+//  string - The Value string (the default).
+//  int    - The Value parsed as an integer.
+//  float  - The Value parsed as a floating-point number.
+//  bool   - The Value parsed as a boolean.
+//  json   - The Value parsed as JSON (e.g. lists, maps, numbers, etc).
+//  list   - A list of the nested item values (their keys are ignored).
+//  map    - A map of the nested item keys to their values.
+//  ref    - The descriptor (e.g. *descriptor.DescriptorProto) that the Value
+//           (a fully-qualified symbol path, e.g. ".pkg.Message") refers to.
+//
 type FileMapDataItem struct {
+	Type  string `xml:"type,attr,omitempty"`
 	Key   string
 	Value string
+
+	// Items are the nested items of list and map types.
+	Items []*FileMapDataItem `xml:"Item,omitempty"`
+}
+
+// DataRef is a data value referring to a descriptor by its fully-qualified
+// symbol path (e.g. ".pkg.Message"). DataRef values are resolved into the
+// actual descriptor before templates are executed.
+//
+// In YAML and JSON filemaps, references are written as a map with a single
+// "$ref" key, e.g. {"$ref": ".pkg.Message"}.
+type DataRef string
+
+// Interface returns the value of the item, according to its type. References
+// are returned as DataRef values.
+func (d *FileMapDataItem) Interface() (interface{}, error) {
+	switch d.Type {
+	case "", "string":
+		return d.Value, nil
+	case "int":
+		return strconv.Atoi(strings.TrimSpace(d.Value))
+	case "float":
+		return strconv.ParseFloat(strings.TrimSpace(d.Value), 64)
+	case "bool":
+		return strconv.ParseBool(strings.TrimSpace(d.Value))
+	case "json":
+		var v interface{}
+		if err := json.Unmarshal([]byte(d.Value), &v); err != nil {
+			return nil, err
+		}
+		return jsonRefs(v), nil
+	case "list":
+		list := make([]interface{}, 0, len(d.Items))
+		for _, item := range d.Items {
+			v, err := item.Interface()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case "map":
+		m := make(map[string]interface{}, len(d.Items))
+		for _, item := range d.Items {
+			if _, ok := m[item.Key]; ok {
+				return nil, fmt.Errorf("duplicate map key %q", item.Key)
+			}
+			v, err := item.Interface()
+			if err != nil {
+				return nil, err
+			}
+			m[item.Key] = v
+		}
+		return m, nil
+	case "ref":
+		return DataRef(strings.TrimSpace(d.Value)), nil
+	default:
+		return nil, fmt.Errorf("unknown data item type %q", d.Type)
+	}
+}
+
+// jsonRefs returns a copy of the decoded JSON value v, with any {"$ref": "..."}
+// maps replaced with DataRef values, recursively.
+func jsonRefs(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := t["$ref"].(string); ok && len(t) == 1 {
+			return DataRef(ref)
+		}
+		m := make(map[string]interface{}, len(t))
+		for k, elem := range t {
+			m[k] = jsonRefs(elem)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, elem := range t {
+			list[i] = jsonRefs(elem)
+		}
+		return list
+	}
+	return v
 }
 
 // FileMapGenerate represents a generate tag.
+//
+// The struct tags describe both the XML format, and the JSON format (which YAML
+// filemaps are converted to).
 type FileMapGenerate struct {
 	// Template is the path of the template file to use for generating the
 	// target.
-	Template string
+	Template string `json:"template"`
 
 	// Target is the target proto file for generation. It must match one of the
 	// input proto files, or else the template will not be executed.
-	Target string `xml:",omitempty"`
+	Target string `xml:",omitempty" json:"target,omitempty"`
+
+	// Package is the target protobuf package for generation, as an alternative
+	// to Target. It must match the package of at least one of the input proto
+	// files, and the template is executed with all of the files declaring it.
+	Package string `xml:",omitempty" json:"package,omitempty"`
+
+	// Symbol is the fully-qualified path of the target service, message, or
+	// enum for generation (e.g. ".pkg.Service"), as an alternative to Target.
+	// The template is executed with the symbol as its root.
+	Symbol string `xml:",omitempty" json:"symbol,omitempty"`
 
 	// Output is the output file to write the executed template contents to.
-	Output string
+	Output string `json:"output"`
 
 	// Include is a list of template files to include for execution of the
-	// template.
-	Include []string `xml:"Includes>Include,omitempty"`
+	// template. Each may also be a glob pattern (e.g. "partials/*.html") which
+	// must match at least one file.
+	Include []string `xml:"Includes>Include,omitempty" json:"include,omitempty"`
 
 	// Data is effectively an map of items to pass onto the template during
-	// execution.
-	Data []*FileMapDataItem `xml:"Data>Item,omitempty"`
+	// execution. It is only used by XML filemaps.
+	Data []*FileMapDataItem `xml:"Data>Item,omitempty" json:"-"`
+
+	// Values is a map of arbitrary (e.g. nested) values to pass onto the
+	// template during execution. It is only used by YAML and JSON filemaps,
+	// as the "data" field.
+	Values map[string]interface{} `xml:"-" json:"data,omitempty"`
+
+	// When is an optional condition, a template pipeline (e.g. `hasParam
+	// "internal"` or `.File.Service`) which is evaluated as {{if When}} before
+	// the generator is executed. If false the generator is skipped. The
+	// pipeline is executed with the target .File, .Package or .Symbol, the
+	// target's .Files, the .Generate element and the .Request.
+	When string `xml:",omitempty" json:"when,omitempty"`
+
+	// IfServices, if true, skips the generator unless the target file (or any
+	// file of the target package, or any file to generate if there is no
+	// target) declares at least one service.
+	IfServices bool `xml:",omitempty" json:"ifServices,omitempty"`
+
+	// IfParam, if non-empty, skips the generator unless the named plugin
+	// parameter is set. If of the form "name=value" the parameter must also be
+	// set to the given value.
+	IfParam string `xml:",omitempty" json:"ifParam,omitempty"`
+
+	// SkipEmpty, if true, skips the output file if the executed template
+	// contains only whitespace.
+	SkipEmpty bool `xml:",omitempty" json:"skipEmpty,omitempty"`
+
+	// node is the XML element this generator was parsed (or expanded) from,
+	// used for error positions. It is nil for non-XML filemaps.
+	node *xmlNode
+
+	// dirs is the list of directories to resolve relative template paths
+	// against, in order: the directory of the filemap the generator was
+	// declared in, its search paths, and then the directories of the filemaps
+	// it imports.
+	dirs []string
+
+	// defaultIncludes is the list of default includes of the filemap the
+	// generator was declared in (including those of the filemaps it imports).
+	defaultIncludes []string
+}
+
+// FileMapForEach represents a ForEach tag, which expands into one generator for
+// each matching file, package, service, message, or enum.
+type FileMapForEach struct {
+	// Kind is the kind of items to generate for: "file", "package", "service",
+	// "message", or "enum".
+	Kind string `xml:"kind,attr" json:"kind"`
+
+	// Template is the path of the template file to use for generating each
+	// item.
+	Template string `json:"template"`
+
+	// Output is a text/template pattern for the output file of each item,
+	// using [[ and ]] as delimiters (so that it doesn't conflict with the
+	// filemap template itself), e.g. "[[dir .File.Name]]/[[.Name]].html".
+	//
+	// The pattern is executed with .Name (the file, package or symbol name),
+	// .FullName (the fully-qualified symbol path), .Package (the package name)
+	// and .File (the file descriptor, nil for packages).
+	Output string `json:"output"`
+
+	// Include, Data and Values are the same as for FileMapGenerate, and are
+	// used for each generator.
+	Include []string               `xml:"Includes>Include,omitempty" json:"include,omitempty"`
+	Data    []*FileMapDataItem     `xml:"Data>Item,omitempty" json:"-"`
+	Values  map[string]interface{} `xml:"-" json:"data,omitempty"`
+
+	// When, IfServices, IfParam and SkipEmpty are the same as for
+	// FileMapGenerate, and are used for each generator.
+	When       string `xml:",omitempty" json:"when,omitempty"`
+	IfServices bool   `xml:",omitempty" json:"ifServices,omitempty"`
+	IfParam    string `xml:",omitempty" json:"ifParam,omitempty"`
+	SkipEmpty  bool   `xml:",omitempty" json:"skipEmpty,omitempty"`
+
+	// Filter optionally filters the items to generate for.
+	Filter *FileMapFilter `xml:",omitempty" json:"filter,omitempty"`
 }
 
-// DataMap returns f.Data but as a Go map. It panics if there are any duplicate
-// keys.
+// FileMapFilter represents a Filter tag, which filters the items that a ForEach
+// tag generates for.
+type FileMapFilter struct {
+	// Package is a list of path.Match patterns, one of which the package name
+	// must match (e.g. "organization.*").
+	Package []string `xml:",omitempty" json:"package,omitempty"`
+
+	// File is a list of path.Match patterns, one of which the file name must
+	// match (e.g. "organization/*.proto"). Packages never match.
+	File []string `xml:",omitempty" json:"file,omitempty"`
+
+	// Option is a list of option names (e.g. "deprecated"), all of which must
+	// be set on the file or symbol options. A "!" prefix (e.g. "!deprecated")
+	// requires the option to be unset instead.
+	Option []string `xml:",omitempty" json:"option,omitempty"`
+}
+
+// DataMap returns f.Data but as a Go map of the (untyped) item values. It
+// panics if there are any duplicate keys. See TypedData for the typed values.
 func (f *FileMapGenerate) DataMap() map[string]string {
 	m := make(map[string]string, len(f.Data))
 	for _, d := range f.Data {
@@ -43,24 +253,61 @@ func (f *FileMapGenerate) DataMap() map[string]string {
 	return m
 }
 
+// TypedData returns f.Data and f.Values merged into a single Go map, with their
+// typed values (see FileMapDataItem), as passed to templates. References to
+// descriptors are left as DataRef values. An error is returned if there are
+// any duplicate keys or invalid values.
+func (f *FileMapGenerate) TypedData() (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(f.Data)+len(f.Values))
+	for _, d := range f.Data {
+		if _, ok := m[d.Key]; ok {
+			return nil, fmt.Errorf("duplicate data key %q", d.Key)
+		}
+		v, err := d.Interface()
+		if err != nil {
+			return nil, fmt.Errorf("data key %q: %s", d.Key, err)
+		}
+		m[d.Key] = v
+	}
+	for k, v := range f.Values {
+		if _, ok := m[k]; ok {
+			return nil, fmt.Errorf("duplicate data key %q", k)
+		}
+		m[k] = jsonRefs(v)
+	}
+	return m, nil
+}
+
 // FileMap represents a file mapping.
 type FileMap struct {
 	// Dir is the directory to resolve template paths mentioned in the filemap
 	// relative to. It should be the directory that this file map resides inside
 	// of. The path will be converted to a unix-style one for protobuf, which
 	// only deals with unix-style paths.
-	Dir string `xml:",omitempty"`
+	Dir string `xml:",omitempty" json:"-"`
 
-	Generate []*FileMapGenerate `xml:"Generate"`
-}
+	// Import is a list of other filemaps to import the generators of, either
+	// paths relative to this filemap or names of filemaps registered in
+	// Generator.FileMaps (e.g. "default"). Generators in this filemap override
+	// imported generators with the same output, as do later imports over
+	// earlier ones.
+	Import []string `xml:"Import,omitempty" json:"import,omitempty"`
 
-// relative returns a list of relative paths prefixed with the f.Dir path (also
-// converted to a unix-style path).
-func (f *FileMap) relative(rel ...string) []string {
-	dir := unixPath(f.Dir)
-	var abs []string
-	for _, p := range rel {
-		abs = append(abs, path.Join(dir, p))
-	}
-	return abs
+	// Remove is a list of outputs of imported generators to remove.
+	Remove []string `xml:"Remove,omitempty" json:"remove,omitempty"`
+
+	// DefaultIncludes is a list of template files (or glob patterns) to include
+	// for every generator in the filemap (and in filemaps importing it), before
+	// the generator's own includes.
+	DefaultIncludes []string `xml:"DefaultIncludes>Include,omitempty" json:"defaultInclude,omitempty"`
+
+	// SearchPath is a list of additional directories (relative to Dir) to
+	// resolve relative template and include paths against, after Dir itself.
+	SearchPath []string `xml:"SearchPaths>Path,omitempty" json:"searchPath,omitempty"`
+
+	Generate []*FileMapGenerate `xml:"Generate" json:"generate"`
+
+	// ForEach is a list of ForEach tags, which are expanded into generators
+	// (appended to Generate) when the filemap is parsed.
+	ForEach []*FileMapForEach `xml:"ForEach,omitempty" json:"foreach,omitempty"`
 }
@@ -66,3 +66,337 @@ func TestFilemap(t *testing.T) {
 		t.Fatal("not equal")
 	}
 }
+
+func TestFileMapFormats(t *testing.T) {
+	files := map[string]string{
+		"maps/filemap.yaml": `
+generate:
+{{- range .ProtoFile}}
+- template: a.html
+  target: {{.Name}}
+  output: {{trimExt .Name}}.html
+  include: [common.html]
+  data:
+    name: {{.Name}}
+    tags: [a, b]
+    nested: {count: 2, enabled: true}
+{{- end}}
+`,
+		"maps/filemap.json": `{
+  "generate": [
+    {{- range $i, $f := .ProtoFile}}{{if $i}},{{end}}
+    {
+      "template": "a.html",
+      "target": "{{.Name}}",
+      "output": "{{trimExt .Name}}.html",
+      "include": ["common.html"],
+      "data": {"name": "{{.Name}}", "tags": ["a", "b"], "nested": {"count": 2, "enabled": true}}
+    }
+    {{- end}}
+  ]
+}`,
+		"maps/a.html":      "",
+		"maps/common.html": "",
+	}
+	for _, path := range []string{"maps/filemap.yaml", "maps/filemap.json"} {
+		g := testGenerator(t, files)
+		if err := g.ParseFileMapFile(path); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if g.FileMap.Dir != "maps" {
+			t.Fatalf("%s: got dir %q want %q", path, g.FileMap.Dir, "maps")
+		}
+		if len(g.FileMap.Generate) != 3 {
+			t.Fatalf("%s: got %d generators want 3", path, len(g.FileMap.Generate))
+		}
+		gen := g.FileMap.Generate[0]
+		want := &FileMapGenerate{
+			Template: "a.html",
+			Target:   "world/human.proto",
+			Output:   "world/human.html",
+			Include:  []string{"common.html"},
+			Values: map[string]interface{}{
+				"name": "world/human.proto",
+				"tags": []interface{}{"a", "b"},
+				"nested": map[string]interface{}{
+					"count":   2.0,
+					"enabled": true,
+				},
+			},
+			dirs: []string{"maps"},
+		}
+		if !reflect.DeepEqual(gen, want) {
+			t.Fatalf("%s: got %#v want %#v", path, gen, want)
+		}
+	}
+}
+
+func TestFileMapFormatsUnescaped(t *testing.T) {
+	// Only XML filemaps are executed as HTML templates.
+	g := testGenerator(t, map[string]string{
+		"filemap.yaml": "generate:\n- {template: a.html, output: '{{\"R&D docs.html\"}}'}\n",
+		"filemap.json": `{"generate": [{"template": "a.html", "output": "a.html", "data": {"q": {{printf "%q" "x"}}}}]}`,
+		"a.html":       "",
+	})
+	if err := g.ParseFileMapFile("filemap.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.FileMap.Generate[0].Output; got != "R&D docs.html" {
+		t.Fatalf("got output %q", got)
+	}
+	g.FileMap = FileMap{}
+	if err := g.ParseFileMapFile("filemap.json"); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.FileMap.Generate[0].Values["q"]; got != "x" {
+		t.Fatalf("got data %q", got)
+	}
+}
+
+func TestFileMapFormatsInvalid(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"filemap.yml": `
+generate:
+- template: a.html
+  output: a.html
+  unknown: true
+`,
+		"a.html": "",
+	})
+	err := g.ParseFileMapFile("filemap.yml")
+	errs, ok := err.(FileMapErrors)
+	if !ok {
+		t.Fatalf("expected FileMapErrors for unknown field, got %v", err)
+	}
+	if e := errs[0]; e.Line != 5 || e.Column != 3 {
+		t.Fatalf("got unknown field error at %d:%d want 5:3", e.Line, e.Column)
+	}
+
+	// Syntax and type errors are positioned too.
+	tests := map[string]string{
+		"filemap.yml":  "generate:\n- template: a.html\n  output: [a.html\n",
+		"filemap.json": "{\"generate\": [\n  {\"template\": \"a.html\", \"output\": \"a.html\"},\n  {\"template\": \"b.html\", \"output\": 2}\n]}",
+		"bad.json":     "{\"generate\": [\n  {\"template\": \"a.html\",\n  \"output\" \"a.html\"}]}",
+	}
+	want := map[string]int{"filemap.yml": 3, "filemap.json": 3, "bad.json": 3}
+	for file, data := range tests {
+		g := testGenerator(t, map[string]string{file: data, "a.html": "", "b.html": ""})
+		errs, ok := g.ParseFileMapFile(file).(FileMapErrors)
+		if !ok || errs[0].Line != want[file] {
+			t.Fatalf("%s: got %v want an error on line %d", file, errs, want[file])
+		}
+	}
+}
+
+func TestFileMapDataTypes(t *testing.T) {
+	tst := `
+    <Generate>
+        <Data>
+            <Item><Key>str</Key><Value>hello</Value></Item>
+            <Item type="int"><Key>int</Key><Value>42</Value></Item>
+            <Item type="float"><Key>float</Key><Value>1.5</Value></Item>
+            <Item type="bool"><Key>bool</Key><Value>true</Value></Item>
+            <Item type="json"><Key>json</Key><Value>{"a": [1, {"$ref": ".pkg.A"}]}</Value></Item>
+            <Item type="list">
+                <Key>list</Key>
+                <Item><Value>a</Value></Item>
+                <Item type="int"><Value>2</Value></Item>
+            </Item>
+            <Item type="map">
+                <Key>map</Key>
+                <Item><Key>x</Key><Value>y</Value></Item>
+                <Item type="ref"><Key>ref</Key><Value>.pkg.B</Value></Item>
+            </Item>
+        </Data>
+    </Generate>
+    `
+	var gen FileMapGenerate
+	if err := xml.Unmarshal([]byte(tst), &gen); err != nil {
+		t.Fatal(err)
+	}
+	got, err := gen.TypedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"str":   "hello",
+		"int":   42,
+		"float": 1.5,
+		"bool":  true,
+		"json":  map[string]interface{}{"a": []interface{}{1.0, DataRef(".pkg.A")}},
+		"list":  []interface{}{"a", 2},
+		"map":   map[string]interface{}{"x": "y", "ref": DataRef(".pkg.B")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v\nwant %#v", got, want)
+	}
+
+	gen.Data = append(gen.Data, &FileMapDataItem{Type: "int", Key: "bad", Value: "x"})
+	if got := gen.DataMap(); got["int"] != "42" || got["list"] != "" {
+		t.Fatalf("got untyped data %v", got)
+	}
+	if _, err := gen.TypedData(); err == nil {
+		t.Fatal("expected error for invalid int value")
+	}
+}
+
+func TestFileMapForEach(t *testing.T) {
+	g := testGenerator(t, map[string]string{"a.html": ""})
+	err := g.ParseFileMap("", `
+<FileMap>
+    <ForEach kind="message">
+        <Template>a.html</Template>
+        <Output>[[dir .File.Name]]/[[.Name]].html</Output>
+        <Filter><File>world/*.proto</File></Filter>
+    </ForEach>
+    <ForEach kind="package">
+        <Template>a.html</Template>
+        <Output>[[packagePath .Name]]/index.html</Output>
+        <Filter><Package>oth*</Package></Filter>
+    </ForEach>
+    <ForEach kind="service">
+        <Template>a.html</Template>
+        <Output>[[.FullName]].html</Output>
+        <Filter><Option>!deprecated</Option></Filter>
+    </ForEach>
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type target struct{ output, target, pkg, symbol string }
+	want := []target{
+		{"world/Human.html", "", "", ".world.Human"},
+		{"world/Building.html", "", "", ".world.Building"},
+		{"world/Building.Floor.html", "", "", ".world.Building.Floor"},
+		{"other/index.html", "", "other", ""},
+		{".world.Builder.html", "", "", ".world.Builder"},
+	}
+	var got []target
+	for _, gen := range g.FileMap.Generate {
+		got = append(got, target{gen.Output, gen.Target, gen.Package, gen.Symbol})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v\nwant %v", got, want)
+	}
+
+	// Errors are positioned at the ForEach element.
+	g = testGenerator(t, map[string]string{})
+	err = g.ParseFileMap("", `
+<FileMap>
+    <ForEach kind="bogus">
+        <Template>a.html</Template>
+        <Output>[[.Name]]</Output>
+    </ForEach>
+</FileMap>
+`)
+	errs, ok := err.(FileMapErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 3 {
+		t.Fatalf("expected a single error on line 3, got %v", err)
+	}
+}
+
+func TestFileMapImport(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"base/filemap.xml": `
+<FileMap>
+    <Generate>
+        <Template>index.html</Template>
+        <Output>index.html</Output>
+        <Includes><Include>common.html</Include></Includes>
+    </Generate>
+    <Generate>
+        <Template>about.html</Template>
+        <Output>about.html</Output>
+    </Generate>
+    <Generate>
+        <Template>index.html</Template>
+        <Output>extra.html</Output>
+        <Includes><Include>common.html</Include></Includes>
+    </Generate>
+</FileMap>`,
+		"base/index.html":   `{{template "Header"}}index`,
+		"base/about.html":   `about`,
+		"base/common.html":  `{{define "Header"}}base:{{end}}`,
+		"team/filemap.yaml": "import: [base]\nremove: [about.html]\ngenerate:\n- {template: index.html, output: extra.html, include: [common.html]}\n",
+		"team/index.html":   `{{template "Header"}}team`,
+	})
+	g.FileMaps = map[string]string{"base": "base/filemap.xml"}
+	if err := g.ParseFileMapFile("team/filemap.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, f := range resp.File {
+		got[f.GetName()] = f.GetContent()
+	}
+	want := map[string]string{
+		"index.html": "base:index",
+		"extra.html": "base:team",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// Import cycles and removing unknown outputs are errors.
+	g = testGenerator(t, map[string]string{
+		"a.xml":  `<FileMap><Import>b.xml</Import><Remove>missing.html</Remove></FileMap>`,
+		"b.xml":  `<FileMap><Import>a.xml</Import><Generate><Template>b.html</Template><Output>b.html</Output></Generate></FileMap>`,
+		"b.html": "",
+	})
+	err = g.ParseFileMapFile("a.xml")
+	errs, ok := err.(FileMapErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected two errors, got %v", err)
+	}
+	if want := "1:32: cannot remove \"missing.html\" (no such imported output)"; errs[0].Error() != want {
+		t.Fatalf("got %q want %q", errs[0].Error(), want)
+	}
+	if want := "b.xml:1:10: import cycle: a.xml -> b.xml -> a.xml"; errs[1].Error() != want {
+		t.Fatalf("got %q want %q", errs[1].Error(), want)
+	}
+}
+
+func TestFileMapIncludes(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"maps/filemap.xml": `
+<FileMap>
+    <SearchPaths><Path>../shared</Path></SearchPaths>
+    <DefaultIncludes><Include>base.html</Include></DefaultIncludes>
+    <Generate>
+        <Template>page.html</Template>
+        <Output>page.html</Output>
+        <Includes><Include>partials/*.html</Include></Includes>
+    </Generate>
+</FileMap>`,
+		"maps/page.html":         `{{template "Base"}} {{template "A"}} {{template "B"}}`,
+		"maps/partials/a.html":   `{{define "A"}}a{{end}}`,
+		"shared/base.html":       `{{define "Base"}}base{{end}}`,
+		"shared/partials/a.html": `{{define "A"}}shared-a{{end}}`,
+		"shared/partials/b.html": `{{define "B"}}shared-b{{end}}`,
+	})
+	if err := g.ParseFileMapFile("maps/filemap.xml"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.File[0].GetContent(), "base a shared-b"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// Patterns which match no files are errors.
+	g = testGenerator(t, map[string]string{"a.html": ""})
+	err = g.ParseFileMap("", `<FileMap>
+    <DefaultIncludes><Include>missing/*.html</Include></DefaultIncludes>
+    <Generate><Template>a.html</Template><Output>a.html</Output></Generate>
+</FileMap>`)
+	errs, ok := err.(FileMapErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 2 {
+		t.Fatalf("expected a single error on line 2, got %v", err)
+	}
+}
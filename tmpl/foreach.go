@@ -0,0 +1,254 @@
+package tmpl
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+// Symbol is a single file, package, or symbol, as matched by a ForEach element
+// (which its Output pattern is executed with) or returned by the symbols
+// template function.
+type Symbol struct {
+	// Name is the name of the file or package, or the name of the symbol
+	// (including any parent message names, e.g. "Outer.Inner").
+	Name string
+
+	// FullName is the fully-qualified symbol path of the symbol, e.g.
+	// ".pkg.Outer.Inner", or an empty string for files and packages.
+	FullName string
+
+	// Package is the name of the package (see util.PackageName).
+	Package string
+
+	// File is the file the symbol is declared inside of (or the file itself),
+	// or nil for packages.
+	File *descriptor.FileDescriptorProto
+
+	// Descriptor is the descriptor of the symbol (e.g. a
+	// *descriptor.DescriptorProto), the file, or the *util.Package.
+	Descriptor interface{}
+
+	// options is the options message of the file or symbol, if any.
+	options proto.Message
+
+	// target sets the target of the generator for this item.
+	target func(gen *FileMapGenerate)
+}
+
+// forEachItems returns all of the items of the given kind ("file", "package",
+// "service", "message" or "enum") in the given files.
+func forEachItems(kind string, files []*descriptor.FileDescriptorProto) ([]*Symbol, error) {
+	var items []*Symbol
+	symbol := func(f *descriptor.FileDescriptorProto, name string, desc interface{}, options proto.Message) *Symbol {
+		fullName := symbolPath(f, name)
+		return &Symbol{
+			Name:       name,
+			FullName:   fullName,
+			Package:    util.PackageName(f),
+			File:       f,
+			Descriptor: desc,
+			options:    options,
+			target:     func(gen *FileMapGenerate) { gen.Symbol = fullName },
+		}
+	}
+	switch kind {
+	case "file":
+		for _, f := range files {
+			name := f.GetName()
+			items = append(items, &Symbol{
+				Name:       name,
+				Package:    util.PackageName(f),
+				File:       f,
+				Descriptor: f,
+				options:    f.GetOptions(),
+				target:     func(gen *FileMapGenerate) { gen.Target = name },
+			})
+		}
+	case "package":
+		for _, pkg := range util.Packages(files) {
+			name := pkg.Name
+			items = append(items, &Symbol{
+				Name:       name,
+				Package:    name,
+				Descriptor: pkg,
+				target:     func(gen *FileMapGenerate) { gen.Package = name },
+			})
+		}
+	case "service":
+		for _, f := range files {
+			for _, s := range f.Service {
+				items = append(items, symbol(f, s.GetName(), s, s.GetOptions()))
+			}
+		}
+	case "message":
+		for _, f := range files {
+			for _, m := range util.AllMessages(f, true) {
+				items = append(items, symbol(f, m.GetName(), m, m.GetOptions()))
+			}
+		}
+	case "enum":
+		for _, f := range files {
+			for _, e := range util.AllEnums(f, true) {
+				items = append(items, symbol(f, e.GetName(), e, e.GetOptions()))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown kind %q (expected file, package, service, message, or enum)", kind)
+	}
+	return items, nil
+}
+
+// match tells if the item matches the filter. A nil filter matches all items.
+func (f *FileMapFilter) match(item *Symbol) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	matchAny := func(patterns []string, name string) (bool, error) {
+		if len(patterns) == 0 {
+			return true, nil
+		}
+		for _, p := range patterns {
+			ok, err := path.Match(p, name)
+			if err != nil {
+				return false, fmt.Errorf("bad pattern %q: %s", p, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if ok, err := matchAny(f.Package, item.Package); !ok || err != nil {
+		return false, err
+	}
+	if len(f.File) > 0 {
+		if item.File == nil {
+			return false, nil
+		}
+		if ok, err := matchAny(f.File, item.File.GetName()); !ok || err != nil {
+			return false, err
+		}
+	}
+	for _, opt := range f.Option {
+		want := !strings.HasPrefix(opt, "!")
+		if optionSet(item.options, strings.TrimPrefix(opt, "!")) != want {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// optionSet tells if the named field (e.g. "deprecated") of the options
+// message is set to a non-zero value. Registered extensions may be named by
+// their full name, optionally in parentheses (e.g. "(google.api.http)"). It
+// returns false for nil options.
+func optionSet(options proto.Message, name string) bool {
+	if options == nil {
+		return false
+	}
+	m := proto.MessageReflect(options)
+	if !m.IsValid() {
+		return false
+	}
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		// Look for a set extension with the full name.
+		fullName := protoreflect.FullName(strings.TrimSuffix(strings.TrimPrefix(name, "("), ")"))
+		m.Range(func(f protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+			if f.IsExtension() && f.FullName() == fullName {
+				fd = f
+				return false
+			}
+			return true
+		})
+	}
+	if fd == nil || !m.Has(fd) {
+		return false
+	}
+	if fd.Kind() == protoreflect.BoolKind {
+		return m.Get(fd).Bool()
+	}
+	return true
+}
+
+// expandForEach expands each of the filemap's ForEach elements into a
+// generator for each matching item, which are returned. The root node is used
+// for error positions (in the given file), and may be nil.
+func (g *Generator) expandForEach(fm *FileMap, file string, root *xmlNode) ([]*FileMapGenerate, error) {
+	var (
+		errs  FileMapErrors
+		gens  []*FileMapGenerate
+		nodes = root.path("ForEach")
+	)
+	for i, fe := range fm.ForEach {
+		n := nthNode(nodes, i)
+		expanded, err := fe.expand(g.request.GetProtoFile(), g.preload())
+		if err != nil {
+			line, column := n.position()
+			errs = append(errs, &FileMapError{File: file, Line: line, Column: column, Msg: err.Error()})
+			continue
+		}
+
+		// Generators expanded from a ForEach element are positioned at it.
+		for _, gen := range expanded {
+			gen.node = n
+		}
+		gens = append(gens, expanded...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return gens, nil
+}
+
+// expand returns a generator for each file, package, or symbol in the given
+// files which matches f. The output pattern is executed with the given
+// functions.
+func (f *FileMapForEach) expand(files []*descriptor.FileDescriptorProto, funcs template.FuncMap) ([]*FileMapGenerate, error) {
+	items, err := forEachItems(f.Kind, files)
+	if err != nil {
+		return nil, err
+	}
+	output, err := template.New("").Delims("[[", "]]").Funcs(funcs).Parse(f.Output)
+	if err != nil {
+		return nil, fmt.Errorf("bad output pattern: %s", err)
+	}
+
+	var gens []*FileMapGenerate
+	for _, item := range items {
+		ok, err := f.Filter.match(item)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := output.Execute(buf, item); err != nil {
+			return nil, fmt.Errorf("bad output pattern: %s", err)
+		}
+		gen := &FileMapGenerate{
+			Template: f.Template,
+			Output:   path.Clean(buf.String()),
+			Include:  f.Include,
+			Data:     f.Data,
+			Values:   f.Values,
+
+			When:       f.When,
+			IfServices: f.IfServices,
+			IfParam:    f.IfParam,
+			SkipEmpty:  f.SkipEmpty,
+		}
+		item.target(gen)
+		gens = append(gens, gen)
+	}
+	return gens, nil
+}
@@ -0,0 +1,564 @@
+package tmpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// stdFuncs is the standard library of string, list, math and map helpers
+// available to filemaps and templates (see funcMap). Like the sprig library,
+// the operand comes last so that the helpers work well in pipelines, e.g.
+// {{.Name | trimPrefix "Get" | lower}}.
+var stdFuncs = map[string]interface{}{
+	// Strings.
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"title":      strings.Title,
+	"untitle":    untitle,
+	"trim":       strings.TrimSpace,
+	"trimAll":    func(cutset, s string) string { return strings.Trim(s, cutset) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       join,
+	"trunc":      trunc,
+	"indent":     indent,
+	"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+	"quote":      strconv.Quote,
+	"cat":        cat,
+	"camelCase":  camelCase,
+	"snakeCase":  func(s string) string { return strings.Join(words(s), "_") },
+	"kebabCase":  func(s string) string { return strings.Join(words(s), "-") },
+	"plural":     plural,
+
+	// Lists.
+	"list":      func(v ...interface{}) []interface{} { return v },
+	"first":     first,
+	"last":      last,
+	"rest":      rest,
+	"initial":   initial,
+	"append":    appendList,
+	"prepend":   prepend,
+	"concat":    concat,
+	"reverse":   reverse,
+	"uniq":      uniq,
+	"has":       has,
+	"sortAlpha": sortAlpha,
+	"until":     until,
+	"seq":       seq,
+
+	// Math (on integers of any type).
+	"add":  func(v ...interface{}) (int64, error) { return fold(v, func(a, b int64) int64 { return a + b }) },
+	"sub":  sub,
+	"mul":  func(v ...interface{}) (int64, error) { return fold(v, func(a, b int64) int64 { return a * b }) },
+	"div":  div,
+	"mod":  mod,
+	"add1": add1,
+	"max":  func(v ...interface{}) (int64, error) { return fold(v, maxInt64) },
+	"min":  func(v ...interface{}) (int64, error) { return fold(v, minInt64) },
+
+	// Maps.
+	"set":    set,
+	"get":    get,
+	"hasKey": hasKey,
+	"keys":   keys,
+	"merge":  merge,
+
+	// Defaults and conversions.
+	"default":  defaultValue,
+	"empty":    empty,
+	"coalesce": coalesce,
+	"ternary":  ternary,
+	"toString": toString,
+	"toInt":    toInt,
+	"toJSON":   toJSON,
+}
+
+// untitle lower-cases the first letter of s.
+func untitle(s string) string {
+	for i, r := range s {
+		return string(unicode.ToLower(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
+
+// join joins the elements of the list (of any type) with sep.
+func join(sep string, list interface{}) (string, error) {
+	l, err := toList(list)
+	if err != nil {
+		return "", err
+	}
+	strs := make([]string, len(l))
+	for i, v := range l {
+		strs[i] = toString(v)
+	}
+	return strings.Join(strs, sep), nil
+}
+
+// trunc truncates s to at most n runes.
+func trunc(n int, s string) string {
+	r := []rune(s)
+	if n < 0 || len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+// indent indents every line of s by the number of spaces.
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// cat joins the non-nil values with spaces.
+func cat(v ...interface{}) string {
+	var strs []string
+	for _, e := range v {
+		if e != nil {
+			strs = append(strs, toString(e))
+		}
+	}
+	return strings.Join(strs, " ")
+}
+
+// words splits s into lower-case words, at non-alphanumeric characters and at
+// lower-to-upper case changes, e.g. "HTTPServerName" -> ["http", "server",
+// "name"].
+func words(s string) []string {
+	var (
+		out  []string
+		word []rune
+		rs   = []rune(s)
+	)
+	flush := func() {
+		if len(word) > 0 {
+			out = append(out, strings.ToLower(string(word)))
+			word = nil
+		}
+	}
+	for i, r := range rs {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(word) > 0 {
+			prev := word[len(word)-1]
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+	return out
+}
+
+// camelCase converts s to CamelCase, e.g. "http_server_name" -> "HttpServerName".
+func camelCase(s string) string {
+	w := words(s)
+	for i, word := range w {
+		w[i] = strings.Title(word)
+	}
+	return strings.Join(w, "")
+}
+
+// plural returns one if count is 1, otherwise many, e.g. {{plural "field"
+// "fields" (len .Field)}}.
+func plural(one, many string, count int) string {
+	if count == 1 {
+		return one
+	}
+	return many
+}
+
+// toList converts a slice or array of any type to a []interface{}. A nil value
+// is an empty list.
+func toList(list interface{}) ([]interface{}, error) {
+	if list == nil {
+		return nil, nil
+	}
+	if l, ok := list.([]interface{}); ok {
+		return l, nil
+	}
+	v := reflect.ValueOf(list)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = v.Index(i).Interface()
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("expected a list, got %T", list)
+}
+
+// first returns the first element of the list, or nil if it is empty.
+func first(list interface{}) (interface{}, error) {
+	l, err := toList(list)
+	if err != nil || len(l) == 0 {
+		return nil, err
+	}
+	return l[0], nil
+}
+
+// last returns the last element of the list, or nil if it is empty.
+func last(list interface{}) (interface{}, error) {
+	l, err := toList(list)
+	if err != nil || len(l) == 0 {
+		return nil, err
+	}
+	return l[len(l)-1], nil
+}
+
+// rest returns all but the first element of the list.
+func rest(list interface{}) ([]interface{}, error) {
+	l, err := toList(list)
+	if err != nil || len(l) == 0 {
+		return nil, err
+	}
+	return l[1:], nil
+}
+
+// initial returns all but the last element of the list.
+func initial(list interface{}) ([]interface{}, error) {
+	l, err := toList(list)
+	if err != nil || len(l) == 0 {
+		return nil, err
+	}
+	return l[:len(l)-1], nil
+}
+
+// appendList returns a new list with v appended to the list.
+func appendList(list interface{}, v interface{}) ([]interface{}, error) {
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]interface{}(nil), l...), v), nil
+}
+
+// prepend returns a new list with v prepended to the list.
+func prepend(list interface{}, v interface{}) ([]interface{}, error) {
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	return append([]interface{}{v}, l...), nil
+}
+
+// concat concatenates the lists.
+func concat(lists ...interface{}) ([]interface{}, error) {
+	var out []interface{}
+	for _, list := range lists {
+		l, err := toList(list)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, l...)
+	}
+	return out, nil
+}
+
+// reverse returns the list in reverse order.
+func reverse(list interface{}) ([]interface{}, error) {
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(l))
+	for i, v := range l {
+		out[len(l)-1-i] = v
+	}
+	return out, nil
+}
+
+// uniq returns the list without duplicate elements, keeping the first of each.
+func uniq(list interface{}) ([]interface{}, error) {
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, v := range l {
+		if ok, _ := has(v, out); !ok {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// has tells if the list contains the needle.
+func has(needle interface{}, list interface{}) (bool, error) {
+	l, err := toList(list)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range l {
+		if reflect.DeepEqual(v, needle) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sortAlpha returns the elements of the list as strings, sorted.
+func sortAlpha(list interface{}) ([]string, error) {
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(l))
+	for i, v := range l {
+		strs[i] = toString(v)
+	}
+	sort.Strings(strs)
+	return strs, nil
+}
+
+// until returns the list of integers [0, n).
+func until(n int) []int {
+	return seq(0, n)
+}
+
+// seq returns the list of integers [start, end).
+func seq(start, end int) []int {
+	var out []int
+	for i := start; i < end; i++ {
+		out = append(out, i)
+	}
+	return out
+}
+
+// toInt converts an integer, float, bool or string of any type to an int64.
+func toInt(v interface{}) (int64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float()), nil
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.String:
+		return strconv.ParseInt(strings.TrimSpace(rv.String()), 10, 64)
+	case reflect.Ptr:
+		if !rv.IsNil() {
+			return toInt(rv.Elem().Interface())
+		}
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", v)
+}
+
+// fold folds the integers with fn, e.g. fold([1, 2, 3], +) == 6.
+func fold(v []interface{}, fn func(a, b int64) int64) (int64, error) {
+	if len(v) == 0 {
+		return 0, fmt.Errorf("expected at least one argument")
+	}
+	acc, err := toInt(v[0])
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range v[1:] {
+		i, err := toInt(e)
+		if err != nil {
+			return 0, err
+		}
+		acc = fn(acc, i)
+	}
+	return acc, nil
+}
+
+// add1 returns v+1.
+func add1(v interface{}) (int64, error) {
+	i, err := toInt(v)
+	return i + 1, err
+}
+
+// sub performs the subtraction a-b.
+func sub(a, b interface{}) (int64, error) {
+	return fold([]interface{}{a, b}, func(a, b int64) int64 { return a - b })
+}
+
+// div performs integer division a/b.
+func div(a, b interface{}) (int64, error) {
+	x, err := toInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return x / y, nil
+}
+
+// mod returns the remainder of a/b.
+func mod(a, b interface{}) (int64, error) {
+	x, err := toInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return x % y, nil
+}
+
+// maxInt64 returns the larger of a and b.
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// minInt64 returns the smaller of a and b.
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// set sets the key of the map to v, and returns the map.
+func set(m map[string]interface{}, key string, v interface{}) map[string]interface{} {
+	m[key] = v
+	return m
+}
+
+// get returns the value of the key in the map, or nil.
+func get(m map[string]interface{}, key string) interface{} {
+	return m[key]
+}
+
+// hasKey tells if the map has the key.
+func hasKey(m map[string]interface{}, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// keys returns the sorted keys of the maps.
+func keys(maps ...map[string]interface{}) []string {
+	var out []string
+	for _, m := range maps {
+		for k := range m {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// merge returns a new map with the keys of all of the maps, earlier maps
+// taking precedence.
+func merge(maps ...map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for i := len(maps) - 1; i >= 0; i-- {
+		for k, v := range maps[i] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// empty tells if v is nil, or the zero value of its type, or an empty list,
+// map or string.
+func empty(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return true
+	}
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+}
+
+// defaultValue returns v, or def if v is empty, e.g. {{.Title | default
+// "Untitled"}}.
+func defaultValue(def, v interface{}) interface{} {
+	if empty(v) {
+		return def
+	}
+	return v
+}
+
+// coalesce returns the first non-empty value, or nil.
+func coalesce(v ...interface{}) interface{} {
+	for _, e := range v {
+		if !empty(e) {
+			return e
+		}
+	}
+	return nil
+}
+
+// ternary returns a if cond is true, otherwise b, e.g. {{ternary "yes" "no"
+// .Deprecated}}.
+func ternary(a, b interface{}, cond bool) interface{} {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// toString converts v to a string, dereferencing pointers (e.g. the *string
+// fields of descriptors).
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case *string:
+		if t == nil {
+			return ""
+		}
+		return *t
+	case fmt.Stringer:
+		return t.String()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return ""
+		}
+		return toString(rv.Elem().Interface())
+	}
+	return fmt.Sprint(v)
+}
+
+// toJSON encodes v as JSON.
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,72 @@
+package tmpl
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+)
+
+func TestStdFuncs(t *testing.T) {
+	var tests = map[string]string{
+		// Strings.
+		`{{upper "abc"}} {{lower "ABC"}} {{title "hello world"}} {{untitle "Hello"}}`: "ABC abc Hello World hello",
+		`{{"GetName" | trimPrefix "Get" | lower}}`:                                    "name",
+		`{{trimSuffix ".proto" "a.proto"}} {{trimAll "-" "--a--"}} {{trim "  a "}}`:   "a a a",
+		`{{hasPrefix "a" "abc"}} {{hasSuffix "c" "abc"}} {{contains "x" "abc"}}`:      "true true false",
+		`{{replace "." "/" "a.b.c"}} {{repeat 3 "ab"}} {{trunc 3 "abcdef"}}`:          "a/b/c ababab abc",
+		`{{join ", " (split "." "a.b.c")}}`:                                           "a, b, c",
+		`{{indent 2 "a\nb"}}|{{nindent 1 "a"}}`:                                       "  a\n  b|\n a",
+		`{{quote "a\"b"}} {{cat "a" 1 nil true}}`:                                     `"a\"b" a 1 true`,
+		`{{camelCase "http_server_name"}} {{snakeCase "HTTPServerName"}}`:             "HttpServerName http_server_name",
+		`{{kebabCase "getUserID2Name"}}`:                                              "get-user-id2-name",
+		`{{plural "field" "fields" 1}} {{plural "field" "fields" 2}}`:                 "field fields",
+
+		// Lists.
+		`{{$l := list 3 1 2}}{{first $l}} {{last $l}} {{rest $l}} {{initial $l}}`:         "3 2 [1 2] [3 1]",
+		`{{append (list 1 2) 3}} {{prepend (list 1 2) 0}} {{concat (list 1) (list 2 3)}}`: "[1 2 3] [0 1 2] [1 2 3]",
+		`{{reverse (list 1 2 3)}} {{uniq (list 1 2 1 3 2)}} {{has 2 (list 1 2)}}`:         "[3 2 1] [1 2 3] true",
+		`{{sortAlpha (list "b" "c" "a")}} {{until 3}} {{seq 2 5}}`:                        "[a b c] [0 1 2] [2 3 4]",
+		`{{first (list)}}`: "<no value>",
+
+		// Math.
+		`{{add 1 2 3}} {{mul 2 3}} {{div 7 2}} {{mod 7 2}} {{add1 1}}`:      "6 6 3 1 2",
+		`{{max 1 5 3}} {{min 4 2 8}} {{add "2" 3}}`:                         "5 2 5",
+		`{{sub (add 1 2) 1}} {{sub (len (list 1 2)) (add1 0)}} {{sub 2 5}}`: "2 1 -3",
+
+		// Maps.
+		`{{$m := dict "a" 1 "b" 2}}{{set $m "c" 3 | keys}} {{get $m "a"}} {{hasKey $m "z"}}`: "[a b c] 1 false",
+		`{{$m := merge (dict "a" 1) (dict "a" 2 "b" 3)}}{{get $m "a"}}{{get $m "b"}}`:        "13",
+
+		// Defaults and conversions.
+		`{{"" | default "none"}} {{"x" | default "none"}} {{empty (list)}} {{empty 0}} {{empty "a"}}`: "none x true true false",
+		`{{coalesce "" 0 "a" "b"}} {{ternary "yes" "no" true}} {{ternary "yes" "no" false}}`:          "a yes no",
+		`{{toString 1}} {{toInt "42"}} {{toJSON (list 1 "a")}}`:                                       `1 42 [1,"a"]`,
+	}
+	for tmpl, want := range tests {
+		tp, err := template.New("").Funcs(Preload).Parse(tmpl)
+		if err != nil {
+			t.Fatalf("%s: %s", tmpl, err)
+		}
+		var buf bytes.Buffer
+		if err := tp.Execute(&buf, nil); err != nil {
+			t.Fatalf("%s: %s", tmpl, err)
+		}
+		if got := buf.String(); got != want {
+			t.Fatalf("%s: got %q want %q", tmpl, got, want)
+		}
+	}
+}
+
+func TestStdFuncsErrors(t *testing.T) {
+	var tests = []string{
+		`{{div 1 0}}`,
+		`{{add "x" 1}}`,
+		`{{sub 1 "x"}}`,
+		`{{first 1}}`,
+	}
+	for _, tmpl := range tests {
+		if err := template.Must(template.New("").Funcs(Preload).Parse(tmpl)).Execute(&bytes.Buffer{}, nil); err == nil {
+			t.Fatalf("%s: expected error", tmpl)
+		}
+	}
+}
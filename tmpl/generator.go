@@ -3,17 +3,35 @@ package tmpl // import "sourcegraph.com/sourcegraph/prototools/tmpl"
 
 import (
 	"bytes"
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"path"
+	"path/filepath"
+	"strings"
 
 	gateway "github.com/grpc-ecosystem/grpc-gateway/protoc-gen-grpc-gateway/descriptor"
 	"github.com/golang/protobuf/proto"
 	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+// Layout describes how the generated output files are laid out, which
+// determines where links to types (see the urlToType template function) point
+// to.
+type Layout int
+
+const (
+	// FileLayout is the default layout, where each input proto file has its own
+	// output file (e.g. "foo/bar.proto" -> "foo/bar.html").
+	FileLayout Layout = iota
+
+	// PackageLayout is a layout where each protobuf package has its own output
+	// file, merging all of the files that declare it (e.g. package "foo.bar" ->
+	// "foo/bar.html").
+	PackageLayout
 )
 
 // Generator is the type whose methods generate the output, stored in the associated response structure.
@@ -31,10 +49,69 @@ type Generator struct {
 	//
 	APIHost string
 
+	// Hosts are the hosts of the API in each environment (see ParseHosts). If
+	// APIHost is empty, the default host (the one without an environment) is
+	// used in its place.
+	Hosts []*Host
+
+	// Links maps packages or symbols (by full name, e.g. "google.protobuf") to
+	// the URLs of their external documentation, which links to their types
+	// point to instead (see the urlToType template function). A URL may contain
+	// "{type}" and "{fullname}" placeholders, otherwise the type name is
+	// appended as the fragment, e.g. "https://example.com/docs#Timestamp".
+	Links map[string]string
+
+	// Data is passed to every template as .Data, along with (and overridden by)
+	// the data of the filemap generators. As in YAML and JSON filemaps,
+	// {"$ref": ".pkg.Type"} values are resolved into descriptors.
+	Data map[string]interface{}
+
+	// Layout is the layout of the generated output files, used to determine
+	// the URLs of generated types.
+	Layout Layout
+
 	// ReadFile if non-nil is used to read template files, otherwise
 	// ioutil.ReadFile is used.
 	ReadFile func(path string) ([]byte, error)
 
+	// Glob if non-nil is used to expand glob patterns of include files,
+	// otherwise filepath.Glob is used.
+	Glob func(pattern string) ([]string, error)
+
+	// SearchPaths is a list of additional directories to resolve relative
+	// template and include paths against, after those of the filemap.
+	SearchPaths []string
+
+	// KeepGoing, if true, makes Generate keep the files of the generators that
+	// succeeded when others fail, rather than failing entirely.
+	KeepGoing bool
+
+	// ErrorReport, if non-empty and KeepGoing is true, is the output file to
+	// write a report of the generator errors to (as JSON if it has a ".json"
+	// extension, otherwise as text with one error per line).
+	ErrorReport string
+
+	// ErrorPlaceholders, if true and KeepGoing is true, writes a placeholder
+	// file describing the error in place of each output that failed.
+	ErrorPlaceholders bool
+
+	// PostProcess if non-nil is called with the output file name and contents
+	// of each generated file, and returns the new contents of the file.
+	PostProcess func(output, content string) (string, error)
+
+	// Params is the map of plugin parameters, used by the param template
+	// function and IfParam generator conditions. If nil, the parameters are
+	// parsed from the request.
+	Params map[string]string
+
+	// FileMaps is a map of names to filemap file paths, which filemaps may
+	// import by name (e.g. <Import>default</Import>) instead of by path.
+	FileMaps map[string]string
+
+	// Exclude, if non-nil, is the policy for excluding (e.g. internal) elements
+	// from the request. It must be set before calling SetRequest.
+	Exclude *Exclude
+
 	// request from protoc compiler, which should be set by the user of this
 	// package via the SetRequest method.
 	request *plugin.CodeGeneratorRequest
@@ -44,33 +121,86 @@ type Generator struct {
 
 	// grpc-gateway registry used to determine HTTP routes.
 	registry *gateway.Registry
+
+	// resolver resolves the symbols of the request, see symbolResolver.
+	resolver *util.Resolver
+
+	// The executed filemap template, as parsed by ParseFileMap.
+	fileMapData []byte
+
+	// Errors of the generators that failed in the last call to Generate.
+	errors []*GenerateError
+
+	// Custom template functions, see Funcs.
+	funcs template.FuncMap
 }
 
-// ParseFileMap parses and executes a filemap template.
+// apiHost returns the APIHost, or the URL of the default host (see Hosts).
+func (g *Generator) apiHost() string {
+	if g.APIHost == "" {
+		if h := defaultHost(g.Hosts); h != nil {
+			return h.URL()
+		}
+	}
+	return g.APIHost
+}
+
+// ParseFileMap parses and executes a XML filemap template.
+//
+// The executed filemap is validated, and if any problems are found (e.g.
+// duplicate outputs, unknown targets, missing templates, or unknown elements)
+// a FileMapErrors list is returned, whose positions refer to the executed
+// filemap (see ExecutedFileMap).
 func (g *Generator) ParseFileMap(dir, data string) error {
-	// Parse the template data.
-	t, err := template.New("").Funcs(Preload).Parse(data)
+	return g.parseFileMap("", dir, data, ".xml")
+}
+
+// ParseFileMapFile reads (using g.ReadFile), parses and executes the filemap
+// template file at the given path. Template paths inside the filemap are
+// relative to the directory of the file.
+//
+// The format of the filemap is chosen by the file extension: ".yaml" and ".yml"
+// for YAML, ".json" for JSON, and XML otherwise. Just like XML filemaps, YAML
+// and JSON filemaps are themselves templates.
+func (g *Generator) ParseFileMapFile(path string) error {
+	data, err := g.readFile()(path)
 	if err != nil {
 		return err
 	}
+	return g.parseFileMap(filepath.Clean(path), filepath.Dir(path), string(data), strings.ToLower(filepath.Ext(path)))
+}
 
+// parseFileMap parses and executes a filemap template, whose format is denoted
+// by the file extension ext. The file is the path of the filemap (used to
+// detect import cycles), or an empty string if not known.
+func (g *Generator) parseFileMap(file, dir, data, ext string) error {
 	// Execute the template.
-	buf := bytes.NewBuffer(nil)
-	err = t.Execute(buf, g.request)
+	executed, err := g.executeFileMap(data, ext)
 	if err != nil {
 		return err
 	}
 
-	// Parse the filemap.
+	// Parse the filemap, and any filemaps it imports.
+	g.fileMapData = executed
 	g.FileMap.Dir = dir
-	err = xml.Unmarshal(buf.Bytes(), &g.FileMap)
+	var importing []string
+	if file != "" {
+		importing = append(importing, file)
+	}
+	errs, err := g.loadFileMap(&g.FileMap, "", executed, ext, importing)
 	if err != nil {
 		return err
 	}
-	if len(g.FileMap.Generate) == 0 {
+	if len(g.FileMap.Generate) == 0 && len(errs) == 0 {
 		return errors.New("no generate elements found in file map")
 	}
-	return nil
+	return g.validate(errs)
+}
+
+// ExecutedFileMap returns the executed filemap template from the last call to
+// ParseFileMap, which is useful for debugging.
+func (g *Generator) ExecutedFileMap() []byte {
+	return g.fileMapData
 }
 
 // Generate generates a response for g.Request (which you should unmarshal data
@@ -78,22 +208,37 @@ func (g *Generator) ParseFileMap(dir, data string) error {
 //
 // If any error is encountered during generation, it is returned and should be
 // considered fatal to the generation process (the response will be nil).
+// Errors executing individual generators are reported in the response's Error
+// field, one per line, and are available in structured form from Errors.
+//
+// If g.KeepGoing is true, the files of the generators which succeeded are kept
+// instead, and the errors are reported via g.ErrorReport and
+// g.ErrorPlaceholders only.
 func (g *Generator) Generate() (response *plugin.CodeGeneratorResponse, err error) {
 	// Reset the response to its initial state.
 	g.response.Reset()
 
 	// Execute each generator.
 	errs := bytes.NewBuffer(nil)
+	g.errors = nil
 	for _, gen := range g.FileMap.Generate {
 		f, err := g.GenerateOutput(gen.Output, nil)
 		if err != nil {
+			g.errors = append(g.errors, g.generateError(gen, err))
 			fmt.Fprintf(errs, "%s\n", err)
 			continue
 		}
+		if f == nil {
+			continue // skipped
+		}
 		g.response.File = append(g.response.File, f)
 	}
 
 	if errs.Len() > 0 {
+		if g.KeepGoing {
+			// Keep the successfully generated files, and report the errors.
+			return g.response, g.reportErrors()
+		}
 		g.response.File = nil
 		errsStr := errs.String()
 		g.response.Error = &errsStr
@@ -101,8 +246,16 @@ func (g *Generator) Generate() (response *plugin.CodeGeneratorResponse, err erro
 	return g.response, nil
 }
 
+// Errors returns the errors of each generator that failed in the last call to
+// Generate.
+func (g *Generator) Errors() []*GenerateError {
+	return g.errors
+}
+
 // GenerateOutput generates a CodeGeneratorResponse_File for the output file
-// name.
+// name. If the generator is skipped (because its conditions are not met, or
+// because its output is empty and SkipEmpty is set), nil is returned. Errors
+// executing the generator are returned as a *GenerateError.
 //
 // The ctx parameter specifies an arbitrary context for which to execute the
 // template with, it is exposed to the executed template file as "Ctx".
@@ -113,12 +266,41 @@ func (g *Generator) GenerateOutput(name string, ctx interface{}) (*plugin.CodeGe
 			continue
 		}
 
+		// Check the generator's conditions.
+		ok, err := g.cond(gen)
+		if err != nil {
+			return nil, g.generateError(gen, err)
+		}
+		if !ok {
+			return nil, nil
+		}
+
 		// Execute in whichever mode is correct.
-		if gen.Target != "" {
-			return g.genTarget(gen, ctx)
-		} else {
-			return g.genNoTarget(gen, ctx)
+		var f *plugin.CodeGeneratorResponse_File
+		switch {
+		case gen.Target != "":
+			f, err = g.genTarget(gen, ctx)
+		case gen.Package != "":
+			f, err = g.genPackage(gen, ctx)
+		case gen.Symbol != "":
+			f, err = g.genSymbol(gen, ctx)
+		default:
+			f, err = g.genNoTarget(gen, ctx)
+		}
+		if err != nil {
+			return nil, g.generateError(gen, err)
 		}
+		if g.PostProcess != nil {
+			content, err := g.PostProcess(gen.Output, f.GetContent())
+			if err != nil {
+				return nil, g.generateError(gen, fmt.Errorf("post-processing: %s", err))
+			}
+			f.Content = &content
+		}
+		if gen.SkipEmpty && strings.TrimSpace(f.GetContent()) == "" {
+			return nil, nil
+		}
+		return f, nil
 	}
 
 	var outputs []string
@@ -130,14 +312,33 @@ func (g *Generator) GenerateOutput(name string, ctx interface{}) (*plugin.CodeGe
 
 // SetRequest sets the request the generator is generating a response for. If an
 // error is returned generation is not safe (the request is bad) until a
-// different request object is set successfully through this method.
+// different request object is set successfully through this method. If an
+// Exclude policy is set, a copy of the request without the excluded elements is
+// used instead.
 func (g *Generator) SetRequest(r *plugin.CodeGeneratorRequest) error {
+	if g.Exclude != nil {
+		var err error
+		r, err = g.Exclude.apply(r)
+		if err != nil {
+			return err
+		}
+	}
 	g.request = r
+	g.resolver = nil
 
 	// Load into the grpc-gateway registry.
 	return g.registry.Load(g.request)
 }
 
+// symbolResolver returns the symbol resolver for the files of the request,
+// which is created once per request.
+func (g *Generator) symbolResolver() *util.Resolver {
+	if g.resolver == nil {
+		g.resolver = util.NewResolver(g.request.GetProtoFile())
+	}
+	return g.resolver
+}
+
 // New returns a new generator for the given template.
 func New() *Generator {
 	return &Generator{
@@ -166,32 +367,29 @@ func (g *Generator) genTarget(gen *FileMapGenerate, userCtx interface{}) (*plugi
 		return nil, fmt.Errorf("no input proto file for generator target %q", gen.Target)
 	}
 
-	// Prepare the generators template.
+	// Prepare the generators template and its data.
 	tmpl, err := g.prepare(gen)
 	if err != nil {
 		return nil, err
 	}
+	data, err := g.data(gen)
+	if err != nil {
+		return nil, err
+	}
 
 	// Execute the template with this context and generate a response
 	// for the input file.
-	ctx := &tmplFuncs{
-		f:          f,
-		outputFile: gen.Output,
-		rootDir:    g.RootDir,
-		protoFile:  protoFile,
-		registry:   g.registry,
-		apiHost:    g.APIHost,
-	}
+	ctx := g.newFuncs(gen, f, nil)
 	err = tmpl.Funcs(ctx.funcMap()).Execute(buf, struct {
 		*descriptor.FileDescriptorProto
 		Generate *FileMapGenerate
-		Data     map[string]string
+		Data     map[string]interface{}
 		Request  *plugin.CodeGeneratorRequest
 		Ctx      interface{}
 	}{
 		f,
 		gen,
-		gen.DataMap(),
+		data,
 		g.request,
 		userCtx,
 	})
@@ -206,39 +404,217 @@ func (g *Generator) genTarget(gen *FileMapGenerate, userCtx interface{}) (*plugi
 	}, nil
 }
 
+// genPackage executes a filemap generator for a specific protobuf package (e.g.
+// for package-oriented doc pages), whose template is executed with all of the
+// files declaring that package.
+func (g *Generator) genPackage(gen *FileMapGenerate, userCtx interface{}) (*plugin.CodeGeneratorResponse_File, error) {
+	var (
+		buf       = bytes.NewBuffer(nil)
+		protoFile = g.request.GetProtoFile()
+		pkg       *util.Package
+	)
+
+	// Find the target package.
+	for _, v := range util.Packages(protoFile) {
+		if gen.Package == v.Name {
+			pkg = v
+			break
+		}
+	}
+	if pkg == nil {
+		return nil, fmt.Errorf("no input proto package for generator package %q", gen.Package)
+	}
+
+	// Prepare the generators template and its data.
+	tmpl, err := g.prepare(gen)
+	if err != nil {
+		return nil, err
+	}
+	data, err := g.data(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute the template with this context and generate a response
+	// for the package.
+	ctx := g.newFuncs(gen, nil, pkg)
+	err = tmpl.Funcs(ctx.funcMap()).Execute(buf, struct {
+		*util.Package
+		Generate *FileMapGenerate
+		Data     map[string]interface{}
+		Request  *plugin.CodeGeneratorRequest
+		Ctx      interface{}
+	}{
+		pkg,
+		gen,
+		data,
+		g.request,
+		userCtx,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the response file with the rendered template.
+	return &plugin.CodeGeneratorResponse_File{
+		Name:    proto.String(gen.Output),
+		Content: proto.String(buf.String()),
+	}, nil
+}
+
+// symbolData is the data that symbol templates are executed with, alongside
+// the symbol descriptor itself (see genSymbol).
+type symbolData struct {
+	// Symbol is the symbol descriptor, e.g. for passing to the location
+	// function or other templates.
+	Symbol util.ASTNode
+
+	// File is the file the symbol is declared in.
+	File *descriptor.FileDescriptorProto
+
+	// Parents is the list of messages the symbol is nested inside of, from the
+	// outermost to the innermost one.
+	Parents []*descriptor.DescriptorProto
+
+	Generate *FileMapGenerate
+	Data     map[string]interface{}
+	Request  *plugin.CodeGeneratorRequest
+	Ctx      interface{}
+}
+
+// lookupSymbol resolves the fully-qualified symbol path into its service,
+// message, or enum descriptor, returning the file it is declared in and the
+// messages it is nested inside of (see symbolData.Parents).
+func (g *Generator) lookupSymbol(symbolPath string) (util.ASTNode, *descriptor.FileDescriptorProto, []*descriptor.DescriptorProto, error) {
+	if len(symbolPath) == 0 || !util.IsFullyQualified(symbolPath) {
+		return nil, nil, nil, fmt.Errorf("symbol %q is not a fully-qualified symbol path", symbolPath)
+	}
+	resolver := g.symbolResolver()
+	node, file := resolver.Resolve(symbolPath, nil)
+	switch node.(type) {
+	case *descriptor.ServiceDescriptorProto, *descriptor.DescriptorProto, *descriptor.EnumDescriptorProto:
+	case nil:
+		return nil, nil, nil, fmt.Errorf("no input proto symbol %q", symbolPath)
+	default:
+		return nil, nil, nil, fmt.Errorf("symbol %q is not a service, message, or enum", symbolPath)
+	}
+
+	// Resolve each of the parent messages, e.g. for ".pkg.A.B.C" in package
+	// "pkg" they are ".pkg.A" and ".pkg.A.B".
+	var (
+		parents []*descriptor.DescriptorProto
+		nested  = util.CountElem(symbolPath) - util.CountElem(file.GetPackage())
+	)
+	for i := nested - 1; i > 0; i-- {
+		parent, ok := resolver.ResolveSymbol(util.TrimElem(symbolPath, -i), nil).(*descriptor.DescriptorProto)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("symbol %q: parent is not a message", symbolPath)
+		}
+		parents = append(parents, parent)
+	}
+	return node, file, parents, nil
+}
+
+// genSymbol executes a filemap generator for a specific service, message, or
+// enum symbol (e.g. for per-type doc pages). The template is executed with the
+// symbol descriptor as its root, alongside the fields of symbolData.
+func (g *Generator) genSymbol(gen *FileMapGenerate, userCtx interface{}) (*plugin.CodeGeneratorResponse_File, error) {
+	buf := bytes.NewBuffer(nil)
+
+	// Find the target symbol.
+	node, f, parents, err := g.lookupSymbol(gen.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare the generators template and its data.
+	tmpl, err := g.prepare(gen)
+	if err != nil {
+		return nil, err
+	}
+	data, err := g.data(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the root context, which embeds the symbol so that its fields can be
+	// accessed directly (e.g. .Name and .Method for services).
+	sd := symbolData{
+		Symbol:   node,
+		File:     f,
+		Parents:  parents,
+		Generate: gen,
+		Data:     data,
+		Request:  g.request,
+		Ctx:      userCtx,
+	}
+	var root interface{}
+	switch n := node.(type) {
+	case *descriptor.ServiceDescriptorProto:
+		root = struct {
+			*descriptor.ServiceDescriptorProto
+			symbolData
+		}{n, sd}
+	case *descriptor.DescriptorProto:
+		root = struct {
+			*descriptor.DescriptorProto
+			symbolData
+		}{n, sd}
+	case *descriptor.EnumDescriptorProto:
+		root = struct {
+			*descriptor.EnumDescriptorProto
+			symbolData
+		}{n, sd}
+	}
+
+	// Execute the template with this context and generate a response for the
+	// symbol.
+	ctx := g.newFuncs(gen, f, nil)
+	err = tmpl.Funcs(ctx.funcMap()).Execute(buf, root)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the response file with the rendered template.
+	return &plugin.CodeGeneratorResponse_File{
+		Name:    proto.String(gen.Output),
+		Content: proto.String(buf.String()),
+	}, nil
+}
+
 // genNoTarget executes a target-less filemap generator (e.g. for index pages
-// rather than individual doc pages). It panics if gen.Target != "".
+// rather than individual doc pages). It panics if gen.Target, gen.Package or
+// gen.Symbol are not empty.
 func (g *Generator) genNoTarget(gen *FileMapGenerate, userCtx interface{}) (*plugin.CodeGeneratorResponse_File, error) {
 	buf := bytes.NewBuffer(nil)
 
 	// Only running generators not on proto files (i.e. generators without
 	// targets).
-	if gen.Target != "" {
+	if gen.Target != "" || gen.Package != "" || gen.Symbol != "" {
 		panic("expected a generator without a target")
 	}
 
-	// Prepare the generators template.
+	// Prepare the generators template and its data.
 	tmpl, err := g.prepare(gen)
 	if err != nil {
 		return nil, err
 	}
+	data, err := g.data(gen)
+	if err != nil {
+		return nil, err
+	}
 
 	// Execute the template with this context and generate a response file.
-	ctx := &tmplFuncs{
-		outputFile: gen.Output,
-		rootDir:    g.RootDir,
-		registry:   g.registry,
-		apiHost:    g.APIHost,
-	}
+	ctx := g.newFuncs(gen, nil, nil)
 	err = tmpl.Funcs(ctx.funcMap()).Execute(buf, struct {
 		*plugin.CodeGeneratorRequest
 		Generate *FileMapGenerate
-		Data     map[string]string
+		Data     map[string]interface{}
 		Ctx      interface{}
 	}{
 		g.request,
 		gen,
-		gen.DataMap(),
+		data,
 		userCtx,
 	})
 	if err != nil {
@@ -252,20 +628,77 @@ func (g *Generator) genNoTarget(gen *FileMapGenerate, userCtx interface{}) (*plu
 	}, nil
 }
 
-// loadTemplate is responsible for loading a single template and associating it
-// with t. It reads the template file from g.ReadFile as appropriate.
-func (g *Generator) loadTemplate(t *template.Template, tmplPath string) (*template.Template, error) {
-	// Make the filepath relative to the filemap.
-	tmplPath = g.FileMap.relative(tmplPath)[0]
+// data returns the data map for the given filemap generator (along with
+// g.Data), with any DataRef values resolved into their descriptors.
+func (g *Generator) data(gen *FileMapGenerate) (map[string]interface{}, error) {
+	m, err := gen.TypedData()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", gen.Output, err)
+	}
+	for k, v := range g.Data {
+		if _, ok := m[k]; !ok {
+			m[k] = jsonRefs(v)
+		}
+	}
+	v, err := g.resolveRefs(m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", gen.Output, err)
+	}
+	return v.(map[string]interface{}), nil
+}
+
+// resolveRefs returns the data value v with any DataRef values resolved into
+// their descriptors, recursively.
+func (g *Generator) resolveRefs(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case DataRef:
+		if len(t) == 0 || !util.IsFullyQualified(string(t)) {
+			return nil, fmt.Errorf("reference %q is not a fully-qualified symbol path", t)
+		}
+		node := g.symbolResolver().ResolveSymbol(string(t), nil)
+		if node == nil {
+			return nil, fmt.Errorf("reference %q: no such symbol", t)
+		}
+		return node, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, elem := range t {
+			r, err := g.resolveRefs(elem)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = r
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, elem := range t {
+			r, err := g.resolveRefs(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = r
+		}
+		return list, nil
+	}
+	return v, nil
+}
 
-	// Determine the open function.
-	readFile := g.ReadFile
-	if readFile == nil {
-		readFile = ioutil.ReadFile
+// readFile returns the function to read template files with, g.ReadFile or
+// ioutil.ReadFile.
+func (g *Generator) readFile() func(path string) ([]byte, error) {
+	if g.ReadFile != nil {
+		return g.ReadFile
 	}
+	return ioutil.ReadFile
+}
 
+// loadTemplate is responsible for loading a single template and associating it
+// with t. It reads the template file (whose path must already be resolved, see
+// findFile) from g.ReadFile as appropriate.
+func (g *Generator) loadTemplate(t *template.Template, tmplPath string) (*template.Template, error) {
 	// Read the file.
-	data, err := readFile(tmplPath)
+	data, err := g.readFile()(tmplPath)
 	if err != nil {
 		return nil, err
 	}
@@ -281,12 +714,16 @@ func (g *Generator) prepare(gen *FileMapGenerate) (*template.Template, error) {
 	// Preload the function map (or else the functions will fail when
 	// called due to a lack of valid context).
 	var (
-		t   = template.New("").Funcs(Preload)
+		t   = template.New("").Funcs(g.preload())
 		err error
 	)
 
-	// Parse the included template files.
-	for _, inc := range gen.Include {
+	// Parse the included template files, expanding any glob patterns.
+	includes, err := g.includes(gen)
+	if err != nil {
+		return nil, err
+	}
+	for _, inc := range includes {
 		_, err := g.loadTemplate(t, inc)
 		if err != nil {
 			return nil, err
@@ -294,7 +731,11 @@ func (g *Generator) prepare(gen *FileMapGenerate) (*template.Template, error) {
 	}
 
 	// Parse the template file to execute.
-	tmpl, err := g.loadTemplate(t, gen.Template)
+	tmplPath, _, err := g.findFile(g.searchDirs(gen), gen.Template)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := g.loadTemplate(t, tmplPath)
 	if err != nil {
 		return nil, err
 	}
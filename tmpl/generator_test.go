@@ -0,0 +1,502 @@
+package tmpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+)
+
+// testRequest returns a request for a small set of proto files:
+//
+//  world/human.proto    (package world)
+//  world/building.proto (package world, imports world/human.proto)
+//  other.proto          (package other)
+//
+func testRequest() *plugin.CodeGeneratorRequest {
+	return &plugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"world/human.proto", "world/building.proto"},
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Name:    proto.String("world/human.proto"),
+				Package: proto.String("world"),
+				MessageType: []*descriptor.DescriptorProto{
+					{
+						Name: proto.String("Human"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{
+								Name:   proto.String("name"),
+								Number: proto.Int32(1),
+								Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:   descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:       proto.String("world/building.proto"),
+				Package:    proto.String("world"),
+				Dependency: []string{"world/human.proto"},
+				MessageType: []*descriptor.DescriptorProto{
+					{
+						Name: proto.String("Building"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{
+								Name:     proto.String("owner"),
+								Number:   proto.Int32(1),
+								Label:    descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+								TypeName: proto.String(".world.Human"),
+							},
+						},
+						NestedType: []*descriptor.DescriptorProto{
+							{Name: proto.String("Floor")},
+						},
+					},
+				},
+				EnumType: []*descriptor.EnumDescriptorProto{
+					{
+						Name: proto.String("Kind"),
+						Value: []*descriptor.EnumValueDescriptorProto{
+							{Name: proto.String("HOUSE"), Number: proto.Int32(0)},
+						},
+					},
+				},
+				Service: []*descriptor.ServiceDescriptorProto{
+					{
+						Name: proto.String("Builder"),
+						Method: []*descriptor.MethodDescriptorProto{
+							{
+								Name:       proto.String("Build"),
+								InputType:  proto.String(".world.Human"),
+								OutputType: proto.String(".world.Building"),
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:    proto.String("other.proto"),
+				Package: proto.String("other"),
+			},
+		},
+	}
+}
+
+// testGenerator returns a new generator for testRequest, whose templates are
+// read from the given map of file paths to contents.
+func testGenerator(t *testing.T, files map[string]string) *Generator {
+	g := New()
+	if err := g.SetRequest(testRequest()); err != nil {
+		t.Fatal(err)
+	}
+	g.ReadFile = func(path string) ([]byte, error) {
+		data, ok := files[path]
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return []byte(data), nil
+	}
+	g.Glob = func(pattern string) ([]string, error) {
+		var matches []string
+		for name := range files {
+			if ok, err := path.Match(pattern, name); err != nil {
+				return nil, err
+			} else if ok {
+				matches = append(matches, name)
+			}
+		}
+		sort.Strings(matches)
+		return matches, nil
+	}
+	return g
+}
+
+func TestGeneratePackage(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"pkg.html": `{{.Name}}:{{range .File}} {{.Name}}{{end}}
+{{range AllMessages true}}{{.Name}}={{urlToType (printf ".world.%s" .GetName)}} {{end}}`,
+	})
+	g.Layout = PackageLayout
+	g.RootDir = "/docs"
+	err := g.ParseFileMap("", `
+<FileMap>
+{{range packages .ProtoFile}}
+    <Generate>
+        <Template>pkg.html</Template>
+        <Package>{{.Name}}</Package>
+        <Output>{{packagePath .Name}}.html</Output>
+    </Generate>
+{{end}}
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Error != nil {
+		t.Fatal(response.GetError())
+	}
+
+	want := map[string]string{
+		"other.html": "other: other.proto\n",
+		"world.html": "world: world/human.proto world/building.proto\nHuman=/docs/world.html#Human Building=/docs/world.html#Building Building.Floor=/docs/world.html#Building.Floor ",
+	}
+	if len(response.File) != len(want) {
+		t.Fatalf("got %d files want %d", len(response.File), len(want))
+	}
+	for _, f := range response.File {
+		if got := f.GetContent(); got != want[f.GetName()] {
+			t.Fatalf("%s: got %q want %q", f.GetName(), got, want[f.GetName()])
+		}
+	}
+}
+
+func TestGeneratePackageUnknown(t *testing.T) {
+	g := testGenerator(t, map[string]string{"pkg.html": ""})
+	g.FileMap.Generate = []*FileMapGenerate{
+		{Template: "pkg.html", Package: "missing", Output: "missing.html"},
+	}
+	_, err := g.GenerateOutput("missing.html", nil)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%q", "missing")) {
+		t.Fatalf("expected unknown package error, got %v", err)
+	}
+}
+
+func TestGenerateDataRefs(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"msg.html": `{{.Data.Message.GetName}} {{range .Data.Message.Field}}{{.GetName}}{{end}} {{.Data.Service.GetName}}`,
+	})
+	err := g.ParseFileMap("", `
+<FileMap>
+    <Generate>
+        <Template>msg.html</Template>
+        <Output>msg.html</Output>
+        <Data>
+            <Item type="ref"><Key>Message</Key><Value>.world.Building</Value></Item>
+            <Item type="ref"><Key>Service</Key><Value>.world.Builder</Value></Item>
+        </Data>
+    </Generate>
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := g.GenerateOutput("msg.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Building owner Builder"; f.GetContent() != want {
+		t.Fatalf("got %q want %q", f.GetContent(), want)
+	}
+
+	// Unresolvable references are reported during validation.
+	g = testGenerator(t, map[string]string{"msg.html": ""})
+	err = g.ParseFileMap("", `
+<FileMap>
+    <Generate>
+        <Template>msg.html</Template>
+        <Output>msg.html</Output>
+        <Data>
+            <Item type="ref"><Key>Message</Key><Value>.world.Missing</Value></Item>
+        </Data>
+    </Generate>
+</FileMap>
+`)
+	errs, ok := err.(FileMapErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 7 {
+		t.Fatalf("expected a single error on line 7, got %v", err)
+	}
+}
+
+func TestGenerateSymbol(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"service.html": `{{.Name}} {{range .Method}}{{.GetName}}{{end}} {{.File.GetName}}`,
+		"message.html": `{{.Name}} {{range .Parents}}{{.GetName}}{{end}} {{.File.GetName}}`,
+	})
+	err := g.ParseFileMap("", `
+<FileMap>
+    {{range $f := .ProtoFile}}
+    {{range $s := .Service}}
+    <Generate>
+        <Template>service.html</Template>
+        <Symbol>{{symbolPath $f $s.GetName}}</Symbol>
+        <Output>{{$s.Name}}.html</Output>
+    </Generate>
+    {{end}}
+    {{end}}
+    <Generate>
+        <Template>message.html</Template>
+        <Symbol>.world.Building.Floor</Symbol>
+        <Output>Floor.html</Output>
+    </Generate>
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Builder.html": "Builder Build world/building.proto",
+		"Floor.html":   "Floor Building world/building.proto",
+	}
+	for output, content := range want {
+		f, err := g.GenerateOutput(output, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.GetContent() != content {
+			t.Fatalf("%s: got %q want %q", output, f.GetContent(), content)
+		}
+	}
+}
+
+func TestGenerateConditions(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"a.html":     "{{.Name}}",
+		"empty.html": "{{if false}}never{{end}}\n",
+	})
+	g.Params = map[string]string{"internal": "", "style": "dark"}
+	err := g.ParseFileMap("", `
+<FileMap>
+    <ForEach kind="file">
+        <Template>a.html</Template>
+        <Output>services/[[.Name]]</Output>
+        <IfServices>true</IfServices>
+    </ForEach>
+    <Generate>
+        <Template>a.html</Template>
+        <Target>world/human.proto</Target>
+        <Output>when.html</Output>
+        <When>and (hasParam "internal") (eq .File.GetName "world/human.proto")</When>
+    </Generate>
+    <Generate>
+        <Template>a.html</Template>
+        <Target>world/human.proto</Target>
+        <Output>when-false.html</Output>
+        <When>param "missing"</When>
+    </Generate>
+    <Generate>
+        <Template>a.html</Template>
+        <Target>world/human.proto</Target>
+        <Output>dark.html</Output>
+        <IfParam>style=dark</IfParam>
+    </Generate>
+    <Generate>
+        <Template>a.html</Template>
+        <Target>world/human.proto</Target>
+        <Output>light.html</Output>
+        <IfParam>style=light</IfParam>
+    </Generate>
+    <Generate>
+        <Template>empty.html</Template>
+        <Target>world/human.proto</Target>
+        <Output>empty.html</Output>
+        <SkipEmpty>true</SkipEmpty>
+    </Generate>
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	var got []string
+	for _, f := range resp.File {
+		got = append(got, f.GetName())
+	}
+	want := []string{"when.html", "dark.html", "services/world/building.proto"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// Invalid conditions are reported when the filemap is parsed.
+	g = testGenerator(t, map[string]string{"a.html": ""})
+	err = g.ParseFileMap("", `<FileMap>
+    <Generate>
+        <Template>a.html</Template>
+        <Output>a.html</Output>
+        <When>noSuchFunc .File</When>
+    </Generate>
+</FileMap>`)
+	errs, ok := err.(FileMapErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 5 {
+		t.Fatalf("expected a single error on line 5, got %v", err)
+	}
+	if strings.Contains(errs[0].Msg, "template:") {
+		t.Fatalf("expected no template position in %q", errs[0].Msg)
+	}
+
+	// Conditions must be a single pipeline.
+	for _, when := range []string{
+		`true)}}{{.Request}}{{if (true`,
+		`false)}}{{else}}{{if (true`,
+		`true)}}true{{end}}{{define "x"}}{{if (true`,
+	} {
+		if _, err := g.condTemplate(when); err == nil {
+			t.Fatalf("%s: expected an error", when)
+		}
+	}
+	if _, err := g.condTemplate(`ne .File.GetName "}}"`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"t/page.html":   "{{template \"Header\" .}}\n{{.Name}}",
+		"t/common.html": "{{define \"Header\"}}\n  {{.NoSuchField}}{{end}}",
+		"t/ok.html":     "ok",
+	})
+	err := g.ParseFileMap("t", `
+<FileMap>
+    <Generate>
+        <Template>page.html</Template>
+        <Symbol>.world.Builder</Symbol>
+        <Output>builder.html</Output>
+        <Includes><Include>common.html</Include></Includes>
+    </Generate>
+    <Generate>
+        <Template>ok.html</Template>
+        <Output>ok.html</Output>
+    </Generate>
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := g.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors want 1: %v", len(errs), errs)
+	}
+	e := errs[0]
+	if e.File != "t/common.html" || e.Line != 2 || e.Symbol != ".world.Builder" || e.Template != "t/page.html" {
+		t.Fatalf("unexpected error context %#v", e)
+	}
+	want := `builder.html (symbol .world.Builder): t/common.html:2:4: executing "Header" at <.NoSuchField>: can't evaluate field NoSuchField`
+	if got := strings.TrimSpace(resp.GetError()); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, " (via page.html -> Header)") {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// The chain of templates leading to the error names the failing template
+	// and the include defining it.
+	wantChain := []TemplateCall{{Name: "page.html", File: "t/page.html"}, {Name: "Header", File: "t/common.html"}}
+	if !reflect.DeepEqual(e.Chain, wantChain) {
+		t.Fatalf("got chain %+v want %+v", e.Chain, wantChain)
+	}
+
+	// Errors can be marshaled as JSON, e.g. for CI annotations.
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["output"] != "builder.html" || m["file"] != "t/common.html" || !strings.Contains(m["error"].(string), "NoSuchField") {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+}
+
+func TestGenerateKeepGoing(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"ok.html":  "ok",
+		"bad.html": "{{.NoSuchField}}",
+	})
+	g.KeepGoing = true
+	g.ErrorReport = "errors.json"
+	g.ErrorPlaceholders = true
+	err := g.ParseFileMap("", `
+<FileMap>
+    <Generate><Template>ok.html</Template><Output>ok.html</Output></Generate>
+    <Generate><Template>bad.html</Template><Target>world/human.proto</Target><Output>bad.html</Output></Generate>
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil {
+		t.Fatalf("unexpected response error %q", resp.GetError())
+	}
+	files := make(map[string]string)
+	for _, f := range resp.File {
+		files[f.GetName()] = f.GetContent()
+	}
+	if files["ok.html"] != "ok" {
+		t.Fatalf("got ok.html %q want %q", files["ok.html"], "ok")
+	}
+	if !strings.Contains(files["bad.html"], "NoSuchField") {
+		t.Fatalf("expected placeholder for bad.html, got %q", files["bad.html"])
+	}
+	var report []map[string]interface{}
+	if err := json.Unmarshal([]byte(files["errors.json"]), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || report[0]["output"] != "bad.html" {
+		t.Fatalf("unexpected error report %s", files["errors.json"])
+	}
+}
+
+func TestGeneratorFuncs(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"a.html": `{{shout .Name}} {{where "x" "y"}}`,
+	})
+	g.Funcs(map[string]interface{}{
+		"shout": strings.ToUpper,
+		"where": func(ctx *Context, args ...string) string {
+			var file string
+			if ctx.File != nil {
+				file = ctx.File.GetName()
+			}
+			return fmt.Sprintf("%s@%s:%s", ctx.Output, file, strings.Join(args, ","))
+		},
+	})
+	err := g.ParseFileMap("", `
+<FileMap>
+    <Generate>
+        <Template>a.html</Template>
+        <Target>world/human.proto</Target>
+        <Output>{{shout "out"}}.html</Output>
+        <When>where</When>
+    </Generate>
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	if got, want := resp.File[0].GetContent(), "WORLD/HUMAN.PROTO OUT.html@world/human.proto:x,y"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+package tmpl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Host is a host that the grpc-gateway routes are served at, i.e. the base URL
+// of the API in an environment.
+type Host struct {
+	// Env is the name of the environment, e.g. "staging", or empty for the
+	// default host.
+	Env string
+
+	// Scheme is the URL scheme, e.g. "https", or empty to render URLs without a
+	// scheme (e.g. "api.mysite.com/v1/shelves").
+	Scheme string
+
+	// Host is the host name, e.g. "api.mysite.com".
+	Host string
+
+	// Port is the port number, or zero for the default port of the scheme.
+	Port int
+
+	// BasePath is the path prefix of all routes, e.g. "/api", or empty.
+	BasePath string
+}
+
+// ParseHost parses a host URL of the form:
+//
+//  [scheme://]host[:port][/base/path]
+//
+// e.g. "https://api.mysite.com:8443/api". The returned host has no Env.
+func ParseHost(s string) (*Host, error) {
+	h := &Host{}
+	rest := s
+	if i := strings.Index(rest, "://"); i >= 0 {
+		h.Scheme, rest = rest[:i], rest[i+3:]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		h.BasePath, rest = rest[i:], rest[:i]
+	}
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.HasSuffix(rest, "]") {
+		port, err := parsePort(rest[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %q: %s", s, err)
+		}
+		h.Port, rest = port, rest[:i]
+	}
+	h.Host = rest
+	h.BasePath = strings.TrimSuffix(h.BasePath, "/")
+	if h.Host == "" {
+		return nil, fmt.Errorf("invalid host %q: missing host name", s)
+	}
+	return h, nil
+}
+
+// parsePort parses a port number.
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// ParseHosts returns the hosts described by the plugin parameters, sorted by
+// environment name (the default host first):
+//
+//  apihost=URL                  the default host, see ParseHost
+//  apihost.ENV=URL              the host of the environment ENV
+//  apihost-scheme[.ENV]=SCHEME  override the scheme of a host
+//  apihost-port[.ENV]=PORT      override the port of a host
+//  apihost-path[.ENV]=PATH      override the base path of a host
+//
+// The overrides allow setting the parts of a host on protoc command lines,
+// which cannot contain colons.
+func ParseHosts(params map[string]string) ([]*Host, error) {
+	var (
+		hosts []*Host
+		byEnv = make(map[string]*Host)
+	)
+	get := func(env string) *Host {
+		h, ok := byEnv[env]
+		if !ok {
+			h = &Host{Env: env}
+			byEnv[env] = h
+			hosts = append(hosts, h)
+		}
+		return h
+	}
+
+	// Parse the hosts before the overrides, which may be for any of them.
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name, env := k, ""
+		if i := strings.Index(k, "."); i >= 0 {
+			name, env = k[:i], k[i+1:]
+		}
+		if name != "apihost" {
+			continue
+		}
+		parsed, err := ParseHost(params[k])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", k, err)
+		}
+		parsed.Env = env
+		*get(env) = *parsed
+	}
+	for _, k := range keys {
+		name, env := k, ""
+		if i := strings.Index(k, "."); i >= 0 {
+			name, env = k[:i], k[i+1:]
+		}
+		if !strings.HasPrefix(name, "apihost-") {
+			continue
+		}
+		h := get(env)
+		v := params[k]
+		switch name {
+		case "apihost-scheme":
+			h.Scheme = v
+		case "apihost-port":
+			port, err := parsePort(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", k, err)
+			}
+			h.Port = port
+		case "apihost-path":
+			h.BasePath = strings.TrimSuffix(v, "/")
+			if h.BasePath != "" && !strings.HasPrefix(h.BasePath, "/") {
+				h.BasePath = "/" + h.BasePath
+			}
+		default:
+			return nil, fmt.Errorf("unknown parameter %s", k)
+		}
+	}
+	for _, h := range hosts {
+		if h.Host == "" {
+			key := "apihost"
+			if h.Env != "" {
+				key += "." + h.Env
+			}
+			return nil, fmt.Errorf("missing %s host name", key)
+		}
+	}
+	sort.SliceStable(hosts, func(i, j int) bool {
+		return hosts[i].Env < hosts[j].Env
+	})
+	return hosts, nil
+}
+
+// URL returns the base URL of the host, without a trailing slash, e.g.
+// "https://api.mysite.com:8443/api".
+func (h *Host) URL() string {
+	var s string
+	if h.Scheme != "" {
+		s = h.Scheme + "://"
+	}
+	s += h.Host
+	if h.Port != 0 {
+		s += ":" + strconv.Itoa(h.Port)
+	}
+	return s + h.BasePath
+}
+
+// Endpoint returns the URL of the path on the host, e.g.
+// "https://api.mysite.com:8443/api/v1/shelves" for "/v1/shelves".
+func (h *Host) Endpoint(path string) string {
+	return h.URL() + path
+}
+
+// String returns the URL of the host.
+func (h *Host) String() string {
+	return h.URL()
+}
+
+// defaultHost returns the host without an environment, or nil.
+func defaultHost(hosts []*Host) *Host {
+	for _, h := range hosts {
+		if h.Env == "" {
+			return h
+		}
+	}
+	return nil
+}
+
+// hosts returns the hosts of every environment, see Generator.Hosts.
+func (f *tmplFuncs) hosts() []*Host {
+	return f.apiHosts
+}
+
+// host returns the host of the environment, or the default host (i.e. the
+// APIHost, if set) for an empty name.
+func (f *tmplFuncs) host(env string) (*Host, error) {
+	if env == "" && f.apiHost != "" {
+		return ParseHost(f.apiHost)
+	}
+	for _, h := range f.apiHosts {
+		if h.Env == env {
+			return h, nil
+		}
+	}
+	if env == "" {
+		return nil, fmt.Errorf("no API host")
+	}
+	return nil, fmt.Errorf("unknown API host environment %q", env)
+}
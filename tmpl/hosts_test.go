@@ -0,0 +1,139 @@
+package tmpl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := map[string]Host{
+		"api.mysite.com":                    {Host: "api.mysite.com"},
+		"http://api.mysite.com/":            {Scheme: "http", Host: "api.mysite.com"},
+		"https://api.mysite.com:8443/api/":  {Scheme: "https", Host: "api.mysite.com", Port: 8443, BasePath: "/api"},
+		"localhost:8080":                    {Host: "localhost", Port: 8080},
+		"https://[::1]:8443":                {Scheme: "https", Host: "[::1]", Port: 8443},
+		"https://[::1]/v1":                  {Scheme: "https", Host: "[::1]", BasePath: "/v1"},
+		"grpc+https://api.mysite.com/a/b/c": {Scheme: "grpc+https", Host: "api.mysite.com", BasePath: "/a/b/c"},
+	}
+	for s, want := range tests {
+		h, err := ParseHost(s)
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if *h != want {
+			t.Fatalf("%s: got %+v want %+v", s, *h, want)
+		}
+	}
+	for _, s := range []string{"", "https://", "api.mysite.com:http", "api.mysite.com:70000/v1"} {
+		if _, err := ParseHost(s); err == nil {
+			t.Fatalf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	hosts, err := ParseHosts(map[string]string{
+		"apihost":              "https://api.mysite.com:8443/api",
+		"apihost.staging":      "staging.mysite.com",
+		"apihost-scheme.local": "http",
+		"apihost.local":        "localhost",
+		"apihost-port.local":   "8080",
+		"apihost-path.staging": "v1/",
+		"apihost-scheme":       "http",
+		"other":                "value",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, h := range hosts {
+		got = append(got, h.Env+"="+h.URL())
+	}
+	want := "=http://api.mysite.com:8443/api local=http://localhost:8080 staging=staging.mysite.com/v1"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("got %q want %q", strings.Join(got, " "), want)
+	}
+
+	for _, params := range []map[string]string{
+		{"apihost-port.prod": "8443"},
+		{"apihost.prod": "api.mysite.com", "apihost-port.prod": "port"},
+		{"apihost.prod": "api.mysite.com", "apihost-user.prod": "me"},
+	} {
+		if _, err := ParseHosts(params); err == nil {
+			t.Fatalf("%v: expected an error", params)
+		}
+	}
+}
+
+func TestHostTemplates(t *testing.T) {
+	g := New()
+	if err := g.SetRequest(routesRequest(t)); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	g.Hosts, err = ParseHosts(map[string]string{
+		"apihost":      "https://api.mysite.com:8443/api",
+		"apihost.test": "http://localhost:8080",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := g.newFuncs(nil, nil, nil)
+	tp := template.Must(template.New("").Funcs(f.funcMap()).Parse(
+		`{{$r := index routes 0}}{{range hosts}}{{.Env}}: {{.Endpoint $r.Path}}` + "\n" +
+			`{{end}}{{curlExample $r "test"}}` + "\n" + `{{(host "").Port}}`,
+	))
+	var buf bytes.Buffer
+	if err := tp.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := ": https://api.mysite.com:8443/api/v1/shelves\ntest: http://localhost:8080/v1/shelves\n" +
+		"curl 'http://localhost:8080/v1/shelves'\n8443"
+	if got := buf.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err := f.host("prod"); err == nil {
+		t.Fatal("expected an error for an unknown environment")
+	}
+
+	// The hosts are the servers of OpenAPI documents.
+	data, err := g.OpenAPI("API", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"url": "https://api.mysite.com:8443/api"`)) ||
+		!bytes.Contains(data, []byte(`"description": "test"`)) {
+		t.Fatalf("missing servers in:\n%s", data)
+	}
+}
+
+func TestHostTemplatesEnvOnly(t *testing.T) {
+	g := New()
+	if err := g.SetRequest(routesRequest(t)); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	g.Hosts, err = ParseHosts(map[string]string{
+		"apihost.staging": "https://staging.mysite.com",
+		"apihost.prod":    "https://api.mysite.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := g.newFuncs(nil, nil, nil)
+
+	// Without a default host, examples are rendered without one.
+	tp := template.Must(template.New("").Funcs(f.funcMap()).Parse(
+		`{{$r := index routes 0}}{{curlExample $r}}` + "\n" + `{{curlExample $r "staging"}}`,
+	))
+	var buf bytes.Buffer
+	if err := tp.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "curl '/v1/shelves'\ncurl 'https://staging.mysite.com/v1/shelves'"
+	if got := buf.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
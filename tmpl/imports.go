@@ -0,0 +1,350 @@
+package tmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/ghodss/yaml"
+)
+
+// executeFileMap executes the filemap template data, whose format is denoted
+// by the file extension ext, with the request. Only XML filemaps are executed
+// as HTML templates, so that the values of YAML and JSON filemaps are not
+// entity-escaped.
+func (g *Generator) executeFileMap(data, ext string) ([]byte, error) {
+	var (
+		t interface {
+			Execute(io.Writer, interface{}) error
+		}
+		err error
+	)
+	switch ext {
+	case ".json", ".yaml", ".yml":
+		t, err = texttemplate.New("").Funcs(texttemplate.FuncMap(g.preload())).Parse(data)
+	default:
+		t, err = template.New("").Funcs(g.preload()).Parse(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, g.request); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeFileMap decodes the executed filemap data, whose format is denoted by
+// the file extension ext, into fm. For XML filemaps the root node of the
+// document is returned (with positions in the given file), otherwise it is nil.
+func decodeFileMap(fm *FileMap, file string, data []byte, ext string) (*xmlNode, error) {
+	switch ext {
+	case ".json", ".yaml", ".yml":
+		// YAML is converted to JSON first, so that both formats use the same
+		// (JSON) struct tags.
+		jsonData := data
+		if ext != ".json" {
+			var err error
+			jsonData, err = yaml.YAMLToJSON(jsonData)
+			if err != nil {
+				return nil, FileMapErrors{decodeError(file, data, ext != ".json", err)}
+			}
+		}
+		d := json.NewDecoder(bytes.NewReader(jsonData))
+		d.DisallowUnknownFields()
+		if err := d.Decode(fm); err != nil {
+			return nil, FileMapErrors{decodeError(file, data, ext != ".json", err)}
+		}
+		return nil, nil
+
+	default:
+		if err := xml.Unmarshal(data, fm); err != nil {
+			if serr, ok := err.(*xml.SyntaxError); ok {
+				return nil, FileMapErrors{{File: file, Line: serr.Line, Column: 1, Msg: serr.Msg}}
+			}
+			return nil, err
+		}
+		return parseXMLNodes(file, data)
+	}
+}
+
+var (
+	// yamlLineRe matches the line number of YAML syntax errors, e.g.
+	// "yaml: line 3: mapping values are not allowed in this context".
+	yamlLineRe = regexp.MustCompile(`yaml: line (\d+):`)
+
+	// unknownFieldRe matches the unknown field errors of the JSON decoder.
+	unknownFieldRe = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+)
+
+// decodeError returns the error for decoding the JSON (or YAML, as denoted by
+// isYAML) filemap data, at the position of the element it refers to if it is
+// known. YAML is decoded as JSON (see decodeFileMap), so the fields of YAML
+// errors are found by the name of their key instead.
+func decodeError(file string, data []byte, isYAML bool, err error) *FileMapError {
+	e := &FileMapError{File: file, Msg: err.Error()}
+	var (
+		key    string
+		offset int64 = -1
+	)
+	switch t := err.(type) {
+	case *json.SyntaxError:
+		e.Line, e.Column = position(data, t.Offset)
+		return e
+	case *json.UnmarshalTypeError:
+		key = t.Field[strings.LastIndex(t.Field, ".")+1:]
+		if !isYAML {
+			offset = t.Offset
+		}
+	default:
+		if m := yamlLineRe.FindStringSubmatch(e.Msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Column = 1
+			return e
+		}
+		if m := unknownFieldRe.FindStringSubmatch(e.Msg); m != nil {
+			key = m[1]
+		}
+	}
+	if key != "" {
+		e.Line, e.Column = keyPosition(data, key, isYAML, offset)
+	}
+	return e
+}
+
+// keyPosition returns the line and column of the object key in the JSON (or
+// YAML) data: the last one before the offset, or the first one if offset is
+// negative. They are zero if there is no such key.
+func keyPosition(data []byte, key string, isYAML bool, offset int64) (line, column int) {
+	re := `"` + regexp.QuoteMeta(key) + `"\s*:`
+	if isYAML {
+		re = `(?m)(?:^|[\s{,-])(["']?` + regexp.QuoteMeta(key) + `["']?)\s*:`
+	}
+	found := int64(-1)
+	for _, m := range regexp.MustCompile(re).FindAllSubmatchIndex(data, -1) {
+		start := int64(m[len(m)-2])
+		if offset >= 0 && start >= offset {
+			break
+		}
+		found = start
+		if offset < 0 {
+			break
+		}
+	}
+	if found < 0 {
+		return 0, 0
+	}
+	return position(data, found)
+}
+
+// loadFileMap decodes the executed filemap data (see decodeFileMap) into fm,
+// expands its ForEach elements, and merges the generators of the filemaps it
+// imports into fm.Generate. The file name is only used for error messages, and
+// is empty for the root filemap. importing is the list of filemap files that
+// are currently being imported, used to detect import cycles.
+//
+// Problems that don't prevent loading the rest of the filemap (e.g. unknown
+// elements or bad imports) are returned as a list, to be reported along with
+// the problems found by validate.
+func (g *Generator) loadFileMap(fm *FileMap, file string, data []byte, ext string, importing []string) (FileMapErrors, error) {
+	root, err := decodeFileMap(fm, file, data, ext)
+	if err != nil {
+		return nil, err
+	}
+	var errs FileMapErrors
+	report := func(n *xmlNode, format string, args ...interface{}) {
+		line, column := n.position()
+		errs = append(errs, &FileMapError{
+			File:   file,
+			Line:   line,
+			Column: column,
+			Msg:    fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Check for unknown elements.
+	if root != nil {
+		if root.name != "FileMap" {
+			report(root, "unknown root element <%s> (expected <FileMap>)", root.name)
+		} else {
+			validateElements(root, xmlSchemaOf(reflect.TypeOf(FileMap{})), report)
+		}
+	}
+
+	// Record the element each generator was declared by.
+	genNodes := root.path("Generate")
+	for i, gen := range fm.Generate {
+		gen.node = nthNode(genNodes, i)
+	}
+	gens, err := g.expandForEach(fm, file, root)
+	if err != nil {
+		return nil, err
+	}
+	fm.Generate = append(fm.Generate, gens...)
+
+	// Load the imported filemaps, later ones overriding the generators of
+	// earlier ones with the same output.
+	var (
+		base            []*FileMapGenerate
+		defaultIncludes []string
+		dirs            = []string{fm.Dir}
+		importNodes     = root.path("Import")
+	)
+
+	// Our own search paths come right after our own directory.
+	for _, p := range fm.SearchPath {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(fm.Dir, p)
+		}
+		dirs = appendDirs(dirs, p)
+	}
+	for i, imp := range fm.Import {
+		n := nthNode(importNodes, i)
+		impPath := g.importPath(fm.Dir, imp)
+		if cycle := importCycle(importing, impPath); cycle != "" {
+			report(n, "import cycle: %s", cycle)
+			continue
+		}
+		impData, err := g.readFile()(impPath)
+		impExt := strings.ToLower(filepath.Ext(impPath))
+		if err != nil {
+			report(n, "bad import: %s", err)
+			continue
+		}
+		executed, err := g.executeFileMap(string(impData), impExt)
+		if err != nil {
+			report(n, "bad import %q: %s", imp, err)
+			continue
+		}
+		impMap := &FileMap{Dir: filepath.Dir(impPath)}
+		impErrs, err := g.loadFileMap(impMap, impPath, executed, impExt, append(importing[:len(importing):len(importing)], impPath))
+		errs = append(errs, impErrs...)
+		if err != nil {
+			if ferrs, ok := err.(FileMapErrors); ok {
+				errs = append(errs, ferrs...)
+				continue
+			}
+			report(n, "bad import %q: %s", imp, err)
+			continue
+		}
+		base = mergeGenerators(base, impMap.Generate)
+		for _, gen := range impMap.Generate {
+			dirs = appendDirs(dirs, gen.dirs...)
+			defaultIncludes = appendDirs(defaultIncludes, gen.defaultIncludes...)
+		}
+	}
+
+	// Remove imported generators by their output.
+	removeNodes := root.path("Remove")
+	for i, output := range fm.Remove {
+		var (
+			kept    []*FileMapGenerate
+			removed bool
+		)
+		for _, gen := range base {
+			if gen.Output == output {
+				removed = true
+				continue
+			}
+			kept = append(kept, gen)
+		}
+		if !removed {
+			report(nthNode(removeNodes, i), "cannot remove %q (no such imported output)", output)
+		}
+		base = kept
+	}
+
+	// Check that the default includes exist.
+	defaultIncludeNodes := root.path("DefaultIncludes", "Include")
+	for i, inc := range fm.DefaultIncludes {
+		if _, err := g.resolveInclude(dirs, inc); err != nil {
+			report(nthNode(defaultIncludeNodes, i), "missing default include: %s", err)
+		}
+	}
+	defaultIncludes = appendDirs(defaultIncludes, fm.DefaultIncludes...)
+
+	// Our own generators resolve relative paths against our own directory
+	// first, and then against the directories of the filemaps we import, so
+	// that shared includes can come from a base filemap. The same goes for
+	// default includes, which are inherited from imported filemaps.
+	for _, gen := range fm.Generate {
+		gen.dirs = dirs
+		gen.defaultIncludes = defaultIncludes
+	}
+	fm.Generate = mergeGenerators(base, fm.Generate)
+	return errs, nil
+}
+
+// importPath returns the path of the imported filemap imp, which is either the
+// name of a filemap in g.FileMaps or a path relative to the directory of the
+// importing filemap.
+func (g *Generator) importPath(dir, imp string) string {
+	if p, ok := g.FileMaps[imp]; ok {
+		return filepath.Clean(p)
+	}
+	if filepath.IsAbs(imp) {
+		return filepath.Clean(imp)
+	}
+	return filepath.Join(dir, imp)
+}
+
+// importCycle returns a description of the import cycle (e.g. "a -> b -> a")
+// that importing p would create, or an empty string if there is no cycle.
+func importCycle(importing []string, p string) string {
+	for i, imp := range importing {
+		if imp == p {
+			cycle := append([]string(nil), importing[i:]...)
+			return strings.Join(append(cycle, p), " -> ")
+		}
+	}
+	return ""
+}
+
+// mergeGenerators returns the base generators with those in overrides merged
+// in: a generator in overrides replaces the base generator with the same
+// output (in-place), or else it is appended. Duplicate outputs within
+// overrides are kept, so that they are reported by validate.
+func mergeGenerators(base, overrides []*FileMapGenerate) []*FileMapGenerate {
+	merged := append([]*FileMapGenerate(nil), base...)
+	index := make(map[string]int, len(base))
+	for i, gen := range base {
+		index[gen.Output] = i
+	}
+	for _, gen := range overrides {
+		if i, ok := index[gen.Output]; ok {
+			merged[i] = gen
+			delete(index, gen.Output)
+			continue
+		}
+		merged = append(merged, gen)
+	}
+	return merged
+}
+
+// appendDirs appends each of the directories (or other paths) not already in
+// dirs to it.
+func appendDirs(dirs []string, add ...string) []string {
+	for _, d := range add {
+		found := false
+		for _, have := range dirs {
+			if have == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
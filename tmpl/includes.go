@@ -0,0 +1,120 @@
+package tmpl
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// searchDirs returns the list of directories to resolve the relative template
+// paths of the generator against: those of the filemap it was declared in (or
+// g.FileMap.Dir), followed by g.SearchPaths.
+func (g *Generator) searchDirs(gen *FileMapGenerate) []string {
+	dirs := []string{g.FileMap.Dir}
+	if len(gen.dirs) > 0 {
+		dirs = append([]string(nil), gen.dirs...)
+	}
+	return appendDirs(dirs, g.SearchPaths...)
+}
+
+// includes returns the paths of all of the generator's include files: its
+// default includes followed by its own, with glob patterns expanded. Files
+// included more than once are only returned once.
+func (g *Generator) includes(gen *FileMapGenerate) ([]string, error) {
+	var (
+		dirs  = g.searchDirs(gen)
+		paths []string
+	)
+	for _, inc := range append(append([]string(nil), gen.defaultIncludes...), gen.Include...) {
+		found, err := g.resolveInclude(dirs, inc)
+		if err != nil {
+			return nil, err
+		}
+		paths = appendDirs(paths, found...)
+	}
+	return paths, nil
+}
+
+// resolveInclude returns the paths of the files matching the include, which is
+// either a relative path or glob pattern resolved against each of the given
+// directories (see findFile and globFiles), or an absolute path.
+func (g *Generator) resolveInclude(dirs []string, inc string) ([]string, error) {
+	if !hasGlobMeta(inc) {
+		p, _, err := g.findFile(dirs, inc)
+		if err != nil {
+			return nil, err
+		}
+		return []string{p}, nil
+	}
+	return g.globFiles(dirs, inc)
+}
+
+// hasGlobMeta tells if the path contains any glob pattern characters.
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// globFiles returns the paths of the files matching the glob pattern, relative
+// to each of the given directories. If the same relative file matches in
+// multiple directories, only the first is returned (so that earlier directories
+// override files in later ones). An error is returned if no files match.
+func (g *Generator) globFiles(dirs []string, pattern string) ([]string, error) {
+	glob := g.Glob
+	if glob == nil {
+		glob = filepath.Glob
+	}
+	if path.IsAbs(pattern) || filepath.IsAbs(pattern) {
+		dirs = []string{""}
+	}
+	var (
+		paths []string
+		seen  = make(map[string]bool)
+	)
+	for _, dir := range dirs {
+		if dir != "" {
+			dir = unixPath(dir)
+		}
+		matches, err := glob(path.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %s", pattern, err)
+		}
+		for _, m := range matches {
+			m = filepath.ToSlash(m)
+			rel := strings.TrimPrefix(strings.TrimPrefix(m, dir), "/")
+			if seen[rel] {
+				continue
+			}
+			seen[rel] = true
+			paths = append(paths, m)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files match %q", pattern)
+	}
+	return paths, nil
+}
+
+// findFile reads the template (or include) file at the relative path p, trying
+// each of the given directories in order. It returns the path of the file that
+// was found and its contents, or the error from the first directory. Absolute
+// paths are read as-is.
+func (g *Generator) findFile(dirs []string, p string) (string, []byte, error) {
+	readFile := g.readFile()
+	if path.IsAbs(p) || filepath.IsAbs(p) {
+		data, err := readFile(p)
+		return p, data, err
+	}
+	var firstErr error
+	for _, dir := range dirs {
+		full := path.Join(unixPath(dir), p)
+		data, err := readFile(full)
+		if err == nil {
+			return full, data, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", nil, firstErr
+}
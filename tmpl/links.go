@@ -0,0 +1,54 @@
+package tmpl
+
+import (
+	"strings"
+)
+
+// externalURL returns the URL of the type in the external documentation given
+// by the links (see Generator.Links), and whether the type is linked to such.
+//
+// The link with the longest matching prefix of the type's full name is used,
+// e.g. "google.protobuf" for ".google.protobuf.Timestamp". If its URL contains
+// "{type}" or "{fullname}" they are replaced by the name of the type inside the
+// package (e.g. "Timestamp") and its full name (e.g.
+// "google.protobuf.Timestamp"), otherwise "#" and the type name are appended.
+func (f *tmplFuncs) externalURL(symbolPath string) (string, bool) {
+	fullName := strings.TrimPrefix(symbolPath, ".")
+	var prefix string
+	for p := range f.links {
+		if (fullName == p || strings.HasPrefix(fullName, p+".")) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		return "", false
+	}
+	url := f.links[prefix]
+
+	// The type name is relative to the package of the type, or else to the
+	// linked prefix.
+	typeName := fullName
+	if pkg := f.symbolPackage(fullName); pkg != "" {
+		typeName = strings.TrimPrefix(fullName, pkg+".")
+	} else if prefix != fullName {
+		typeName = strings.TrimPrefix(fullName, prefix+".")
+	}
+	if !strings.Contains(url, "{type}") && !strings.Contains(url, "{fullname}") {
+		return url + "#" + typeName, true
+	}
+	r := strings.NewReplacer("{type}", typeName, "{fullname}", fullName)
+	return r.Replace(url), true
+}
+
+// symbolPackage returns the longest package of the files which is a prefix of
+// the full name of the symbol, or an empty string.
+func (f *tmplFuncs) symbolPackage(fullName string) string {
+	var best string
+	for _, file := range f.protoFile {
+		pkg := file.GetPackage()
+		if strings.HasPrefix(fullName, pkg+".") && len(pkg) > len(best) {
+			best = pkg
+		}
+	}
+	return best
+}
@@ -0,0 +1,42 @@
+package tmpl
+
+import "testing"
+
+func TestGenerateLinksAndData(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"page.html": `{{urlToType ".world.Human"}} {{urlToType ".world.Building.Floor"}} {{urlToType ".other.Thing"}}
+{{.Data.Title}} {{.Data.Message.GetName}} {{.Data.Override}}`,
+	})
+	g.Links = map[string]string{
+		"other":          "https://example.com/other/{type}.html?q={fullname}",
+		"world.Building": "https://example.com/building",
+	}
+	g.Data = map[string]interface{}{
+		"Title":    "Library API",
+		"Message":  map[string]interface{}{"$ref": ".world.Human"},
+		"Override": "config",
+	}
+	err := g.ParseFileMap("", `
+<FileMap>
+    <Generate>
+        <Template>page.html</Template>
+        <Output>page.html</Output>
+        <Data>
+            <Item><Key>Override</Key><Value>filemap</Value></Item>
+        </Data>
+    </Generate>
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := g.GenerateOutput("page.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "world/human.html#Human https://example.com/building#Building.Floor https://example.com/other/Thing.html?q=other.Thing\n" +
+		"Library API Human filemap"
+	if f.GetContent() != want {
+		t.Fatalf("got %q want %q", f.GetContent(), want)
+	}
+}
@@ -0,0 +1,525 @@
+package tmpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification documents are
+// generated for, see Generator.OpenAPI.
+const OpenAPIVersion = "3.0.3"
+
+// openAPIDoc is an OpenAPI 3 document, see https://spec.openapis.org/oas/v3.0.3.
+type openAPIDoc struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers,omitempty"`
+	Tags       []openAPITag                            `json:"tags,omitempty"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	AllOf                []*openAPISchema          `json:"allOf,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Deprecated           bool                      `json:"deprecated,omitempty"`
+}
+
+// jsonContent returns the content map of a JSON request or response body.
+func jsonContent(schema *openAPISchema) map[string]*openAPIMediaType {
+	return map[string]*openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// OpenAPI returns an OpenAPI 3 document (as indented JSON) describing the
+// grpc-gateway HTTP routes of the files to generate (see Routes), with the
+// given title and API version. Schemas are derived from the messages using the
+// proto3 JSON mapping, and descriptions from the comments of the files.
+func (g *Generator) OpenAPI(title, version string) ([]byte, error) {
+	return g.newFuncs(nil, nil, nil).openAPIJSON(title, version)
+}
+
+// openAPI is the template function version of Generator.OpenAPI.
+func (f *tmplFuncs) openAPI(title, version string) (template.HTML, error) {
+	data, err := f.openAPIJSON(title, version)
+	return template.HTML(data), err
+}
+
+// openAPIJSON returns the OpenAPI document, see Generator.OpenAPI.
+func (f *tmplFuncs) openAPIJSON(title, version string) ([]byte, error) {
+	routes, err := f.routes()
+	if err != nil {
+		return nil, err
+	}
+	b := &openAPIBuilder{
+		f:            f,
+		descriptions: descriptions(f.protoFile),
+		schemas:      make(map[string]*openAPISchema),
+	}
+	doc := &openAPIDoc{
+		OpenAPI:    OpenAPIVersion,
+		Info:       openAPIInfo{Title: title, Version: version},
+		Paths:      make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{Schemas: b.schemas},
+	}
+	// The servers are the default host followed by those of the environments.
+	if f.apiHost != "" {
+		doc.Servers = []openAPIServer{{URL: strings.TrimSuffix(f.apiHost, "/")}}
+	}
+	for _, h := range f.apiHosts {
+		if h.Env != "" {
+			doc.Servers = append(doc.Servers, openAPIServer{URL: h.URL(), Description: h.Env})
+		}
+	}
+	tags := make(map[*descriptor.ServiceDescriptorProto]bool)
+	for _, r := range routes {
+		if !tags[r.Service] {
+			tags[r.Service] = true
+			doc.Tags = append(doc.Tags, openAPITag{
+				Name:        r.Service.GetName(),
+				Description: b.descriptions[r.Service],
+			})
+		}
+		op, err := b.operation(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s): %s", r, r.Method, err)
+		}
+		p := openAPIPath(r.Path)
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[p][strings.ToLower(r.Verb)] = op
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// openAPIPath returns the OpenAPI path for the path template, in which the
+// variables are just the field paths, e.g. "/v1/{name}:cancel" for
+// "/v1/{name=shelves/*}:cancel".
+func openAPIPath(tmpl string) string {
+	var buf strings.Builder
+	for _, p := range splitPathTemplate(tmpl) {
+		if p.variable {
+			buf.WriteString("{" + p.fieldPath + "}")
+		} else {
+			buf.WriteString(p.literal)
+		}
+	}
+	return buf.String()
+}
+
+// pathPatterns returns the patterns of the variables of the path template by
+// their field paths, e.g. {"name": "shelves/*"} for "/v1/{name=shelves/*}".
+func pathPatterns(tmpl string) map[string]string {
+	patterns := make(map[string]string)
+	for _, p := range splitPathTemplate(tmpl) {
+		if p.pattern != "" {
+			patterns[p.fieldPath] = p.pattern
+		}
+	}
+	return patterns
+}
+
+// openAPIBuilder builds the operations and schemas of an OpenAPI document.
+type openAPIBuilder struct {
+	f *tmplFuncs
+
+	// descriptions are the comments of the descriptors, see descriptions.
+	descriptions map[interface{}]string
+
+	// schemas are the component schemas by name, e.g. "pkg.Msg".
+	schemas map[string]*openAPISchema
+}
+
+// operation returns the operation of the route.
+func (b *openAPIBuilder) operation(r *Route) (*openAPIOperation, error) {
+	m := r.Descriptor
+	summary, description := splitDescription(b.descriptions[m])
+	op := &openAPIOperation{
+		OperationID: r.Service.GetName() + "_" + m.GetName(),
+		Summary:     summary,
+		Description: description,
+		Tags:        []string{r.Service.GetName()},
+		Deprecated:  m.GetOptions().GetDeprecated(),
+	}
+	if r.Binding.Index > 0 {
+		op.OperationID += fmt.Sprint(r.Binding.Index + 1)
+	}
+	input, ok := b.f.symbol(m.GetInputType()).(*descriptor.DescriptorProto)
+	if !ok {
+		return nil, fmt.Errorf("unknown input type %s", m.GetInputType())
+	}
+
+	// Path parameters.
+	bound := make(map[string]bool)
+	patterns := pathPatterns(r.Path)
+	for _, p := range r.PathParams {
+		bound[p] = true
+		param := &openAPIParameter{Name: p, In: "path", Required: true, Schema: &openAPISchema{Type: "string"}}
+		if fd := b.fieldByPath(m.GetInputType(), p); fd != nil {
+			param.Description = b.descriptions[fd]
+			param.Schema = b.fieldSchema(fd)
+		}
+		if pattern, ok := patterns[p]; ok {
+			if param.Description != "" {
+				param.Description += "\n\n"
+			}
+			param.Description += fmt.Sprintf("Must match the pattern `%s`.", pattern)
+		}
+		op.Parameters = append(op.Parameters, param)
+	}
+
+	// The request body, and the query parameters (the top-level scalar fields
+	// which are not bound otherwise).
+	switch r.Body {
+	case "*":
+		op.RequestBody = &openAPIBody{Required: true, Content: jsonContent(b.messageRef(m.GetInputType()))}
+	case "":
+	default:
+		bound[r.Body] = true
+		fd := b.fieldByPath(m.GetInputType(), r.Body)
+		if fd == nil {
+			return nil, fmt.Errorf("unknown body field %q", r.Body)
+		}
+		op.RequestBody = &openAPIBody{Required: true, Content: jsonContent(b.fieldSchema(fd))}
+	}
+	if r.Body != "*" {
+		for _, fd := range input.Field {
+			if bound[fd.GetName()] || fd.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE ||
+				fd.GetType() == descriptor.FieldDescriptorProto_TYPE_GROUP {
+				continue
+			}
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name:        jsonName(fd),
+				In:          "query",
+				Description: b.descriptions[fd],
+				Schema:      b.fieldSchema(fd),
+			})
+		}
+	}
+
+	// The response.
+	var response *openAPISchema
+	if r.ResponseBody != "" {
+		fd := b.fieldByPath(m.GetOutputType(), r.ResponseBody)
+		if fd == nil {
+			return nil, fmt.Errorf("unknown response body field %q", r.ResponseBody)
+		}
+		response = b.fieldSchema(fd)
+	} else {
+		response = b.messageRef(m.GetOutputType())
+	}
+	op.Responses = map[string]*openAPIResponse{
+		"200": {Description: "A successful response.", Content: jsonContent(response)},
+	}
+	return op, nil
+}
+
+// splitDescription splits the comments into a summary (the first paragraph,
+// if followed by others) and the rest of the description.
+func splitDescription(comments string) (summary, description string) {
+	parts := strings.SplitN(comments, "\n\n", 2)
+	if len(parts) == 2 {
+		return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	}
+	return "", comments
+}
+
+// fieldByPath returns the field with the (dot-separated) path in the message
+// type, or nil if there is none.
+func (b *openAPIBuilder) fieldByPath(msgType, fieldPath string) *descriptor.FieldDescriptorProto {
+	var fd *descriptor.FieldDescriptorProto
+	for _, name := range strings.Split(fieldPath, ".") {
+		if fd != nil {
+			msgType = fd.GetTypeName()
+		}
+		msg, ok := b.f.symbol(msgType).(*descriptor.DescriptorProto)
+		if !ok {
+			return nil
+		}
+		fd = nil
+		for _, field := range msg.Field {
+			if field.GetName() == name {
+				fd = field
+				break
+			}
+		}
+		if fd == nil {
+			return nil
+		}
+	}
+	return fd
+}
+
+// wellKnownSchemas are the schemas of the well-known types with special JSON
+// mappings.
+var wellKnownSchemas = map[string]openAPISchema{
+	".google.protobuf.Timestamp":   {Type: "string", Format: "date-time"},
+	".google.protobuf.Duration":    {Type: "string"},
+	".google.protobuf.FieldMask":   {Type: "string"},
+	".google.protobuf.Struct":      {Type: "object"},
+	".google.protobuf.Value":       {},
+	".google.protobuf.ListValue":   {Type: "array", Items: &openAPISchema{}},
+	".google.protobuf.Empty":       {Type: "object"},
+	".google.protobuf.Any":         {Type: "object"},
+	".google.protobuf.DoubleValue": {Type: "number", Format: "double"},
+	".google.protobuf.FloatValue":  {Type: "number", Format: "float"},
+	".google.protobuf.Int64Value":  {Type: "string", Format: "int64"},
+	".google.protobuf.UInt64Value": {Type: "string", Format: "uint64"},
+	".google.protobuf.Int32Value":  {Type: "integer", Format: "int32"},
+	".google.protobuf.UInt32Value": {Type: "integer", Format: "int64"},
+	".google.protobuf.BoolValue":   {Type: "boolean"},
+	".google.protobuf.StringValue": {Type: "string"},
+	".google.protobuf.BytesValue":  {Type: "string", Format: "byte"},
+}
+
+// messageRef returns a reference to the schema of the message type (adding it
+// to the components), or the schema of a well-known type.
+func (b *openAPIBuilder) messageRef(typeName string) *openAPISchema {
+	if s, ok := wellKnownSchemas[typeName]; ok {
+		return &s
+	}
+	name := strings.TrimPrefix(typeName, ".")
+	ref := &openAPISchema{Ref: "#/components/schemas/" + name}
+	if _, ok := b.schemas[name]; ok {
+		return ref
+	}
+	switch t := b.f.symbol(typeName).(type) {
+	case *descriptor.DescriptorProto:
+		schema := &openAPISchema{
+			Type:        "object",
+			Description: b.descriptions[t],
+			Deprecated:  t.GetOptions().GetDeprecated(),
+			Properties:  make(map[string]*openAPISchema),
+		}
+		// Added before the fields, for recursive types.
+		b.schemas[name] = schema
+		for _, fd := range t.Field {
+			s := b.fieldSchema(fd)
+			if desc := b.descriptions[fd]; desc != "" {
+				s = describe(s, desc)
+			}
+			schema.Properties[jsonName(fd)] = s
+		}
+	case *descriptor.EnumDescriptorProto:
+		schema := &openAPISchema{Type: "string", Description: b.descriptions[t]}
+		for _, v := range t.Value {
+			schema.Enum = append(schema.Enum, v.GetName())
+		}
+		b.schemas[name] = schema
+	default:
+		// Unknown types (e.g. of files missing from the request) may be any JSON
+		// value.
+		return &openAPISchema{}
+	}
+	return ref
+}
+
+// describe returns the schema with the description, wrapping references (whose
+// siblings are ignored) in allOf.
+func describe(s *openAPISchema, description string) *openAPISchema {
+	if s.Ref != "" {
+		return &openAPISchema{AllOf: []*openAPISchema{s}, Description: description}
+	}
+	s.Description = description
+	return s
+}
+
+// fieldSchema returns the schema of the field's JSON value.
+func (b *openAPIBuilder) fieldSchema(fd *descriptor.FieldDescriptorProto) *openAPISchema {
+	if entry := b.mapEntry(fd); entry != nil {
+		return &openAPISchema{Type: "object", AdditionalProperties: b.typeSchema(entry.Field[1])}
+	}
+	s := b.typeSchema(fd)
+	if fd.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		return &openAPISchema{Type: "array", Items: s}
+	}
+	return s
+}
+
+// mapEntry returns the map entry message of a map field, or nil.
+func (b *openAPIBuilder) mapEntry(fd *descriptor.FieldDescriptorProto) *descriptor.DescriptorProto {
+	if fd.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		return nil
+	}
+	msg, ok := b.f.symbol(fd.GetTypeName()).(*descriptor.DescriptorProto)
+	if !ok || !msg.GetOptions().GetMapEntry() || len(msg.Field) != 2 {
+		return nil
+	}
+	return msg
+}
+
+// typeSchema returns the schema of a single value of the field's type, using
+// the proto3 JSON mapping (e.g. 64-bit integers are strings).
+func (b *openAPIBuilder) typeSchema(fd *descriptor.FieldDescriptorProto) *openAPISchema {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case descriptor.FieldDescriptorProto_TYPE_INT32,
+		descriptor.FieldDescriptorProto_TYPE_SINT32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case descriptor.FieldDescriptorProto_TYPE_UINT32,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return &openAPISchema{Type: "string", Format: "int64"}
+	case descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return &openAPISchema{Type: "string", Format: "uint64"}
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return &openAPISchema{Type: "boolean"}
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return &openAPISchema{Type: "string"}
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return &openAPISchema{Type: "string", Format: "byte"}
+	}
+	// Messages, groups and enums.
+	return b.messageRef(fd.GetTypeName())
+}
+
+// jsonName returns the JSON name of the field, e.g. "fooBar" for "foo_bar".
+func jsonName(fd *descriptor.FieldDescriptorProto) string {
+	if fd.JsonName != nil {
+		return fd.GetJsonName()
+	}
+	var (
+		buf   strings.Builder
+		upper bool
+	)
+	for _, r := range fd.GetName() {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// descriptions returns the (leading, or else trailing) comments of the
+// messages, fields, enums, services and methods of the files.
+func descriptions(files []*descriptor.FileDescriptorProto) map[interface{}]string {
+	var (
+		d    = make(map[interface{}]string)
+		locs map[string]*descriptor.SourceCodeInfo_Location
+	)
+	add := func(node interface{}, p []int32) {
+		loc := locs[pathKey(p)]
+		if loc == nil {
+			return
+		}
+		c := loc.GetLeadingComments()
+		if strings.TrimSpace(c) == "" {
+			c = loc.GetTrailingComments()
+		}
+		// Remove the space after each "//" of the comment lines.
+		lines := strings.Split(c, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimPrefix(line, " ")
+		}
+		if c = strings.TrimSpace(strings.Join(lines, "\n")); c != "" {
+			d[node] = c
+		}
+	}
+	var addMessages func(list []int32, msgs []*descriptor.DescriptorProto)
+	addEnums := func(list []int32, enums []*descriptor.EnumDescriptorProto) {
+		for i, e := range enums {
+			add(e, elem(list, i))
+		}
+	}
+	addMessages = func(list []int32, msgs []*descriptor.DescriptorProto) {
+		for i, m := range msgs {
+			p := elem(list, i)
+			add(m, p)
+			for j, fd := range m.Field {
+				add(fd, elem(field(p, 2), j))
+			}
+			addMessages(field(p, 3), m.NestedType)
+			addEnums(field(p, 4), m.EnumType)
+		}
+	}
+	for _, f := range files {
+		locs = locations(f)
+		addMessages([]int32{4}, f.MessageType)
+		addEnums([]int32{5}, f.EnumType)
+		for i, s := range f.Service {
+			p := elem([]int32{6}, i)
+			add(s, p)
+			for j, m := range s.Method {
+				add(m, elem(field(p, 2), j))
+			}
+		}
+	}
+	return d
+}
@@ -0,0 +1,126 @@
+package tmpl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func TestOpenAPI(t *testing.T) {
+	r := routesRequest(t)
+	f := r.ProtoFile[0]
+	count := &descriptor.FieldDescriptorProto{
+		Name:     proto.String("book_count"),
+		JsonName: proto.String("bookCount"),
+		Number:   proto.Int32(2),
+		Label:    descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     descriptor.FieldDescriptorProto_TYPE_INT64.Enum(),
+	}
+	f.MessageType[0].Field = append(f.MessageType[0].Field, count)
+	f.SourceCodeInfo = &descriptor.SourceCodeInfo{
+		Location: []*descriptor.SourceCodeInfo_Location{
+			{Path: []int32{4, 0}, LeadingComments: proto.String(" A shelf of books.\n")},
+			{Path: []int32{4, 0, 2, 1}, TrailingComments: proto.String(" The number of books.\n")},
+			{Path: []int32{6, 0, 2, 0}, LeadingComments: proto.String(" Gets a shelf.\n\n Fails if there is no such shelf.\n")},
+		},
+	}
+
+	g := New()
+	g.APIHost = "https://api.example.com/"
+	if err := g.SetRequest(r); err != nil {
+		t.Fatal(err)
+	}
+	data, err := g.OpenAPI("Library", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc openAPIDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.OpenAPI != OpenAPIVersion || doc.Info.Title != "Library" || doc.Info.Version != "v1" {
+		t.Fatalf("unexpected header %+v %+v", doc.OpenAPI, doc.Info)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "https://api.example.com" {
+		t.Fatalf("unexpected servers %+v", doc.Servers)
+	}
+	var paths []string
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	for _, p := range []string{"/v1/shelves", "/v1/shelves/{name}", "/v1/{name}", "/v1/{shelf}/books", "/v2/books"} {
+		if doc.Paths[p] == nil {
+			t.Fatalf("missing path %s in %v", p, paths)
+		}
+	}
+
+	get := doc.Paths["/v1/{name}"]["get"]
+	if get.OperationID != "Library_GetShelf" || get.Summary != "Gets a shelf." || get.Description != "Fails if there is no such shelf." {
+		t.Fatalf("unexpected GetShelf operation %+v", get)
+	}
+	if len(get.Parameters) != 1 || get.Parameters[0].In != "path" || get.Parameters[0].Description != "Must match the pattern `shelves/*`." {
+		t.Fatalf("unexpected GetShelf parameters %+v", get.Parameters[0])
+	}
+	if got := get.Responses["200"].Content["application/json"].Schema.Ref; got != "#/components/schemas/lib.Shelf" {
+		t.Fatalf("unexpected GetShelf response %q", got)
+	}
+
+	create := doc.Paths["/v1/{shelf}/books"]["post"]
+	if got := create.RequestBody.Content["application/json"].Schema.Ref; got != "#/components/schemas/lib.Book" {
+		t.Fatalf("unexpected CreateBook body %q", got)
+	}
+	if additional := doc.Paths["/v2/books"]["post"]; additional.OperationID != "Library_CreateBook2" {
+		t.Fatalf("unexpected additional binding operation %q", additional.OperationID)
+	}
+
+	shelf := doc.Components.Schemas["lib.Shelf"]
+	if shelf == nil || shelf.Description != "A shelf of books." {
+		t.Fatalf("unexpected Shelf schema %+v", shelf)
+	}
+	want := &openAPISchema{Type: "string", Format: "int64", Description: "The number of books."}
+	if got := shelf.Properties["bookCount"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got bookCount schema %+v want %+v", got, want)
+	}
+	if list := doc.Components.Schemas["lib.ListShelvesResponse"]; list != nil {
+		t.Fatalf("response body message should not be a component: %+v", list)
+	}
+}
+
+func TestOpenAPITemplate(t *testing.T) {
+	data, err := ioutil.ReadFile("../templates/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New()
+	if err := g.SetRequest(routesRequest(t)); err != nil {
+		t.Fatal(err)
+	}
+	g.Params = map[string]string{"openapi-title": "Library"}
+	g.ReadFile = func(path string) ([]byte, error) {
+		if path != "openapi.json" {
+			return nil, os.ErrNotExist
+		}
+		return data, nil
+	}
+	err = g.ParseFileMap("", `<FileMap><Generate><Template>openapi.json</Template><Output>openapi.json</Output></Generate></FileMap>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc openAPIDoc
+	if err := json.Unmarshal([]byte(resp.File[0].GetContent()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Info.Title != "Library" || doc.Info.Version != "1.0.0" {
+		t.Fatalf("unexpected info %+v", doc.Info)
+	}
+}
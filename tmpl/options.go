@@ -0,0 +1,320 @@
+package tmpl
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// option returns the value of the named custom option (an extension of the
+// options message, e.g. "mycorp.api.visibility" or "(mycorp.api.visibility)")
+// of the descriptor or symbol, or nil if it is not set (or if it extends the
+// options of another kind of descriptor).
+//
+// Options are always decoded using the extension definitions of the request,
+// whether or not their Go types are compiled in, so that their values have the
+// same shape either way:
+//
+//  int32, int64, uint32, uint64, float32, float64, bool, string, []byte
+//  enums: the name of the value, e.g. "PUBLIC"
+//  messages: a map of field names to values
+//  repeated options: a list of values
+//
+func (f *tmplFuncs) option(node interface{}, name string) (interface{}, error) {
+	name = strings.TrimPrefix(strings.TrimSuffix(strings.TrimPrefix(name, "("), ")"), ".")
+	ext, _ := f.symbol("." + name).(*descriptor.FieldDescriptorProto)
+	if ext == nil {
+		return nil, fmt.Errorf("unknown option %q", name)
+	}
+
+	options := itemOptions(node)
+	if options == nil {
+		return nil, nil
+	}
+	m := proto.MessageReflect(options)
+	if !m.IsValid() || ext.GetExtendee() != "."+string(m.Descriptor().FullName()) {
+		return nil, nil
+	}
+
+	// Extensions whose Go types are compiled in are decoded already, so the
+	// options are encoded again to decode them like any other.
+	data, err := proto.Marshal(options)
+	if err != nil {
+		return nil, fmt.Errorf("option %q: %s", name, err)
+	}
+	v, err := f.decodeField(ext, data)
+	if err != nil {
+		return nil, fmt.Errorf("option %q: %s", name, err)
+	}
+	return v, nil
+}
+
+// hasOption tells if the named option (a field of the options message, e.g.
+// "deprecated", or a custom option) of the descriptor or symbol is set. An
+// error is returned for unknown custom options or undecodable values.
+func (f *tmplFuncs) hasOption(node interface{}, name string) (bool, error) {
+	options := itemOptions(node)
+	if optionSet(options, name) {
+		return true, nil
+	}
+	if options != nil && proto.MessageReflect(options).Descriptor().Fields().ByName(protoreflect.Name(name)) != nil {
+		return false, nil
+	}
+	v, err := f.option(node, name)
+	return v != nil, err
+}
+
+// symbol returns the message, enum or extension descriptor with the given
+// fully-qualified name (e.g. ".pkg.Msg"), or nil if there is none.
+func (f *tmplFuncs) symbol(fullName string) interface{} {
+	if f.symbols == nil {
+		f.symbols = make(map[string]interface{})
+		for _, file := range f.protoFile {
+			scope := ""
+			if pkg := file.GetPackage(); pkg != "" {
+				scope = "." + pkg
+			}
+			for _, ext := range file.Extension {
+				f.symbols[scope+"."+ext.GetName()] = ext
+			}
+			for _, e := range file.EnumType {
+				f.symbols[scope+"."+e.GetName()] = e
+			}
+			f.indexMessages(scope, file.MessageType)
+		}
+	}
+	return f.symbols[fullName]
+}
+
+// indexMessages adds the given messages declared in the scope (along with their
+// nested messages, enums and extensions) to the symbols index.
+func (f *tmplFuncs) indexMessages(scope string, msgs []*descriptor.DescriptorProto) {
+	for _, m := range msgs {
+		name := scope + "." + m.GetName()
+		f.symbols[name] = m
+		for _, ext := range m.Extension {
+			f.symbols[name+"."+ext.GetName()] = ext
+		}
+		for _, e := range m.EnumType {
+			f.symbols[name+"."+e.GetName()] = e
+		}
+		f.indexMessages(name, m.NestedType)
+	}
+}
+
+// decodeField decodes the values of the field from the wire-format data of
+// the message containing it. It returns nil if the field is not present.
+func (f *tmplFuncs) decodeField(fd *descriptor.FieldDescriptorProto, b []byte) (interface{}, error) {
+	var (
+		values []interface{}
+		num    = protowire.Number(fd.GetNumber())
+	)
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return nil, protowire.ParseError(l)
+		}
+		b = b[l:]
+		if n != num {
+			l = protowire.ConsumeFieldValue(n, typ, b)
+			if l < 0 {
+				return nil, protowire.ParseError(l)
+			}
+			b = b[l:]
+			continue
+		}
+		vs, l, err := f.decodeValue(fd, typ, b)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, vs...)
+		b = b[l:]
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if fd.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		return values, nil
+	}
+
+	// The last value wins, except that messages are merged.
+	last := values[len(values)-1]
+	if _, ok := last.(map[string]interface{}); ok {
+		merged := make(map[string]interface{})
+		for _, v := range values {
+			for k, fv := range v.(map[string]interface{}) {
+				merged[k] = fv
+			}
+		}
+		return merged, nil
+	}
+	return last, nil
+}
+
+// decodeValue decodes the value(s) of the field with the given wire type (more
+// than one for packed repeated fields), returning them and the number of bytes
+// consumed.
+func (f *tmplFuncs) decodeValue(fd *descriptor.FieldDescriptorProto, typ protowire.Type, b []byte) ([]interface{}, int, error) {
+	switch typ {
+	case protowire.VarintType:
+		v, l := protowire.ConsumeVarint(b)
+		if l < 0 {
+			return nil, 0, protowire.ParseError(l)
+		}
+		x, err := f.decodeVarint(fd, v)
+		return []interface{}{x}, l, err
+
+	case protowire.Fixed32Type:
+		v, l := protowire.ConsumeFixed32(b)
+		if l < 0 {
+			return nil, 0, protowire.ParseError(l)
+		}
+		x, err := decodeFixed32(fd, v)
+		return []interface{}{x}, l, err
+
+	case protowire.Fixed64Type:
+		v, l := protowire.ConsumeFixed64(b)
+		if l < 0 {
+			return nil, 0, protowire.ParseError(l)
+		}
+		x, err := decodeFixed64(fd, v)
+		return []interface{}{x}, l, err
+
+	case protowire.StartGroupType:
+		v, l := protowire.ConsumeGroup(protowire.Number(fd.GetNumber()), b)
+		if l < 0 {
+			return nil, 0, protowire.ParseError(l)
+		}
+		x, err := f.decodeMessage(fd.GetTypeName(), v)
+		return []interface{}{x}, l, err
+
+	case protowire.BytesType:
+		v, l := protowire.ConsumeBytes(b)
+		if l < 0 {
+			return nil, 0, protowire.ParseError(l)
+		}
+		switch fd.GetType() {
+		case descriptor.FieldDescriptorProto_TYPE_STRING:
+			return []interface{}{string(v)}, l, nil
+		case descriptor.FieldDescriptorProto_TYPE_BYTES:
+			return []interface{}{append([]byte(nil), v...)}, l, nil
+		case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+			x, err := f.decodeMessage(fd.GetTypeName(), v)
+			return []interface{}{x}, l, err
+		}
+		values, err := f.decodePacked(fd, v)
+		return values, l, err
+	}
+	return nil, 0, fmt.Errorf("field %s: unexpected wire type %d", fd.GetName(), typ)
+}
+
+// decodePacked decodes the values of a packed repeated scalar field.
+func (f *tmplFuncs) decodePacked(fd *descriptor.FieldDescriptorProto, b []byte) ([]interface{}, error) {
+	var typ protowire.Type
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32,
+		descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		typ = protowire.Fixed32Type
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64,
+		descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		typ = protowire.Fixed64Type
+	case descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return nil, fmt.Errorf("field %s: unexpected wire type %d", fd.GetName(), protowire.BytesType)
+	default:
+		typ = protowire.VarintType
+	}
+	var values []interface{}
+	for len(b) > 0 {
+		vs, l, err := f.decodeValue(fd, typ, b)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, vs...)
+		b = b[l:]
+	}
+	return values, nil
+}
+
+// decodeVarint decodes a varint value of the field.
+func (f *tmplFuncs) decodeVarint(fd *descriptor.FieldDescriptorProto, v uint64) (interface{}, error) {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_INT32:
+		return int32(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_INT64:
+		return int64(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT32:
+		return uint32(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT64:
+		return v, nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		return int32(protowire.DecodeZigZag(v & math.MaxUint32)), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return protowire.DecodeZigZag(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return v != 0, nil
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		n := int32(v)
+		if e, ok := f.symbol(fd.GetTypeName()).(*descriptor.EnumDescriptorProto); ok {
+			for _, ev := range e.Value {
+				if ev.GetNumber() == n {
+					return ev.GetName(), nil
+				}
+			}
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("field %s: unexpected varint for %s", fd.GetName(), fd.GetType())
+}
+
+// decodeFixed32 decodes a 32-bit value of the field.
+func decodeFixed32(fd *descriptor.FieldDescriptorProto, v uint32) (interface{}, error) {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return v, nil
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return int32(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return math.Float32frombits(v), nil
+	}
+	return nil, fmt.Errorf("field %s: unexpected fixed32 for %s", fd.GetName(), fd.GetType())
+}
+
+// decodeFixed64 decodes a 64-bit value of the field.
+func decodeFixed64(fd *descriptor.FieldDescriptorProto, v uint64) (interface{}, error) {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return v, nil
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return int64(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return math.Float64frombits(v), nil
+	}
+	return nil, fmt.Errorf("field %s: unexpected fixed64 for %s", fd.GetName(), fd.GetType())
+}
+
+// decodeMessage decodes the wire-format data of the named message type into a
+// map of its (set) field names to their values.
+func (f *tmplFuncs) decodeMessage(typeName string, b []byte) (map[string]interface{}, error) {
+	msg, ok := f.symbol(typeName).(*descriptor.DescriptorProto)
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %q", typeName)
+	}
+	values := make(map[string]interface{})
+	for _, fd := range msg.Field {
+		v, err := f.decodeField(fd, b)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			values[fd.GetName()] = v
+		}
+	}
+	return values, nil
+}
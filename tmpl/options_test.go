@@ -0,0 +1,173 @@
+package tmpl
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// optionsRequestFiles returns a file declaring custom options (as in the
+// request, without compiled Go types) and a file using them on its fields.
+func optionsRequestFiles() []*descriptor.FileDescriptorProto {
+	ext := func(name string, number int32, label descriptor.FieldDescriptorProto_Label, typ descriptor.FieldDescriptorProto_Type, typeName string) *descriptor.FieldDescriptorProto {
+		fd := &descriptor.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    label.Enum(),
+			Type:     typ.Enum(),
+			Extendee: proto.String(".google.protobuf.FieldOptions"),
+		}
+		if typeName != "" {
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+	var (
+		optional = descriptor.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptor.FieldDescriptorProto_LABEL_REPEATED
+	)
+	api := &descriptor.FileDescriptorProto{
+		Name:    proto.String("mycorp/api.proto"),
+		Package: proto.String("mycorp.api"),
+		EnumType: []*descriptor.EnumDescriptorProto{{
+			Name: proto.String("Visibility"),
+			Value: []*descriptor.EnumValueDescriptorProto{
+				{Name: proto.String("PUBLIC"), Number: proto.Int32(0)},
+				{Name: proto.String("INTERNAL"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Limit"),
+			Field: []*descriptor.FieldDescriptorProto{
+				{Name: proto.String("max"), Number: proto.Int32(1), Label: optional.Enum(), Type: descriptor.FieldDescriptorProto_TYPE_SINT32.Enum()},
+				{Name: proto.String("unit"), Number: proto.Int32(2), Label: optional.Enum(), Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum()},
+			},
+		}},
+		Extension: []*descriptor.FieldDescriptorProto{
+			ext("visibility", 50000, optional, descriptor.FieldDescriptorProto_TYPE_ENUM, ".mycorp.api.Visibility"),
+			ext("scopes", 50001, repeated, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+			ext("limit", 50002, optional, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".mycorp.api.Limit"),
+			ext("weights", 50003, repeated, descriptor.FieldDescriptorProto_TYPE_DOUBLE, ""),
+		},
+	}
+
+	var limit []byte
+	limit = protowire.AppendTag(limit, 1, protowire.VarintType)
+	limit = protowire.AppendVarint(limit, protowire.EncodeZigZag(-5))
+	limit = protowire.AppendTag(limit, 2, protowire.BytesType)
+	limit = protowire.AppendString(limit, "qps")
+
+	var packed []byte
+	packed = protowire.AppendFixed64(packed, 0x3ff8000000000000) // 1.5
+	packed = protowire.AppendFixed64(packed, 0x4000000000000000) // 2
+
+	var unknown []byte
+	unknown = protowire.AppendTag(unknown, 50000, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 1)
+	unknown = protowire.AppendTag(unknown, 50001, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, "read")
+	unknown = protowire.AppendTag(unknown, 50001, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, "write")
+	unknown = protowire.AppendTag(unknown, 50002, protowire.BytesType)
+	unknown = protowire.AppendBytes(unknown, limit)
+	unknown = protowire.AppendTag(unknown, 50003, protowire.BytesType)
+	unknown = protowire.AppendBytes(unknown, packed)
+
+	options := &descriptor.FieldOptions{}
+	proto.MessageReflect(options).SetUnknown(unknown)
+	svc := &descriptor.FileDescriptorProto{
+		Name:       proto.String("mycorp/svc.proto"),
+		Package:    proto.String("mycorp.svc"),
+		Dependency: []string{"mycorp/api.proto"},
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Account"),
+			Field: []*descriptor.FieldDescriptorProto{
+				{Name: proto.String("id"), Number: proto.Int32(1)},
+				{Name: proto.String("secret"), Number: proto.Int32(2), Options: options},
+			},
+		}},
+	}
+	return []*descriptor.FileDescriptorProto{api, svc}
+}
+
+func TestOption(t *testing.T) {
+	files := optionsRequestFiles()
+	var tests = map[string]string{
+		`{{option .Secret "mycorp.api.visibility"}}`:                                          "INTERNAL",
+		`{{option .Secret "(mycorp.api.visibility)"}} {{option .ID "mycorp.api.visibility"}}`: "INTERNAL <no value>",
+		`{{join "," (option .Secret "mycorp.api.scopes")}}`:                                   "read,write",
+		`{{with option .Secret "mycorp.api.limit"}}{{.max}} {{.unit}}{{end}}`:                 "-5 qps",
+		`{{option .Secret "mycorp.api.weights"}}`:                                             "[1.5 2]",
+		`{{option .Message "mycorp.api.visibility"}}`:                                         "<no value>",
+		`{{range .Message.Field | withOption "mycorp.api.scopes"}}{{.GetName}}{{end}}`:        "secret",
+		`{{range .Message.Field | withoutOption "(mycorp.api.limit)"}}{{.GetName}}{{end}}`:    "id",
+	}
+	msg := files[1].MessageType[0]
+	data := map[string]interface{}{
+		"Message": msg,
+		"ID":      msg.Field[0],
+		"Secret":  msg.Field[1],
+	}
+	for tmpl, want := range tests {
+		f := &tmplFuncs{protoFile: files}
+		tp, err := template.New("").Funcs(f.funcMap()).Parse(tmpl)
+		if err != nil {
+			t.Fatalf("%s: %s", tmpl, err)
+		}
+		var buf bytes.Buffer
+		if err := tp.Execute(&buf, data); err != nil {
+			t.Fatalf("%s: %s", tmpl, err)
+		}
+		if got := buf.String(); got != want {
+			t.Fatalf("%s: got %q want %q", tmpl, got, want)
+		}
+	}
+
+	f := &tmplFuncs{protoFile: files}
+	if _, err := f.option(msg.Field[1], "mycorp.api.nope"); err == nil || !strings.Contains(err.Error(), `unknown option "mycorp.api.nope"`) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestOptionCompiledIn(t *testing.T) {
+	// The google.api.http option has a compiled in Go type, but is decoded
+	// using the request's definitions like any other option.
+	options := &descriptor.MethodOptions{}
+	rule := &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/shelves"}}
+	if err := proto.SetExtension(options, annotations.E_Http, rule); err != nil {
+		t.Fatal(err)
+	}
+	method := &descriptor.MethodDescriptorProto{Name: proto.String("ListShelves"), Options: options}
+	f := &tmplFuncs{}
+	for _, path := range []string{"google/api/http.proto", "google/api/annotations.proto"} {
+		fd, err := protoregistry.GlobalFiles.FindFileByPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.protoFile = append(f.protoFile, protodesc.ToFileDescriptorProto(fd))
+	}
+	v, err := f.option(method, "google.api.http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"get": "/v1/shelves"}; !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %#v want %#v", v, want)
+	}
+
+	// Unknown options are reported by the filters too.
+	if _, err := f.withOption("mycorp.api.nope", []interface{}{method}); err == nil {
+		t.Fatal("expected an error for an unknown option")
+	}
+	if _, err := f.withoutOption("deprecated", []interface{}{method}); err != nil {
+		t.Fatal(err)
+	}
+}
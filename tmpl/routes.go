@@ -0,0 +1,379 @@
+package tmpl
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	gateway "github.com/grpc-ecosystem/grpc-gateway/protoc-gen-grpc-gateway/descriptor"
+)
+
+// Route is a single HTTP binding (see google.api.http) of a gRPC method, as
+// returned by Generator.Routes.
+type Route struct {
+	// Verb is the HTTP method, e.g. "GET".
+	Verb string
+
+	// Path is the path template, e.g. "/v1/{name=shelves/*}:publish".
+	Path string
+
+	// Body is the request field mapped to the request body, "*" for the whole
+	// request, or empty if there is no request body.
+	Body string
+
+	// ResponseBody is the response field mapped to the response body, or
+	// empty for the whole response.
+	ResponseBody string
+
+	// PathParams are the request fields bound in the path, e.g. "name".
+	PathParams []string
+
+	// Method is the full name of the gRPC method, e.g. "/pkg.Service/Method".
+	Method string
+
+	// Additional is whether the route is one of the additional_bindings of the
+	// method, rather than its primary binding.
+	Additional bool
+
+	// File, Service and Descriptor are the file, service and method the route
+	// is declared in.
+	File       *descriptor.FileDescriptorProto
+	Service    *descriptor.ServiceDescriptorProto
+	Descriptor *descriptor.MethodDescriptorProto
+
+	// Binding is the grpc-gateway binding of the route.
+	Binding *gateway.Binding
+
+	// Conflicts are the other routes with the same verb and an equivalent path
+	// template (e.g. "/v1/{id}" and "/v1/{name}"), which cannot be told apart.
+	Conflicts []*Route
+}
+
+// String returns the verb and path of the route, e.g. "GET /v1/shelves".
+func (r *Route) String() string {
+	return r.Verb + " " + r.Path
+}
+
+// RouteGroup is a group of routes with the same path prefix, as returned by
+// GroupRoutes.
+type RouteGroup struct {
+	// Prefix is the leading literal segments of the paths, e.g. "/v1/shelves"
+	// for "/v1/shelves/{shelf}/books".
+	Prefix string
+
+	// Routes are the routes in the group, in their original order.
+	Routes []*Route
+}
+
+// Routes returns the HTTP routes of every method of the files to generate,
+// including additional bindings, sorted by path, verb and method. Conflicting
+// routes are reported by their Conflicts fields.
+func (g *Generator) Routes() ([]*Route, error) {
+	return routes(g.registry, g.request)
+}
+
+// routes returns the HTTP routes of the request, see Generator.Routes.
+func routes(registry *gateway.Registry, r *plugin.CodeGeneratorRequest) ([]*Route, error) {
+	if registry == nil || r == nil {
+		return nil, nil
+	}
+	var all []*Route
+	for _, name := range r.FileToGenerate {
+		file, err := registry.LookupFile(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range file.Services {
+			for _, m := range s.Methods {
+				for _, b := range m.Bindings {
+					all = append(all, newRoute(file, s, m, b))
+				}
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if va, vb := verbOrder(a.Verb), verbOrder(b.Verb); va != vb {
+			return va < vb
+		}
+		if a.Verb != b.Verb {
+			return a.Verb < b.Verb
+		}
+		return a.Method < b.Method
+	})
+
+	// Find the conflicting routes.
+	byPattern := make(map[string][]*Route)
+	for _, r := range all {
+		key := r.Verb + " " + pathPattern(r.Path)
+		for _, other := range byPattern[key] {
+			other.Conflicts = append(other.Conflicts, r)
+			r.Conflicts = append(r.Conflicts, other)
+		}
+		byPattern[key] = append(byPattern[key], r)
+	}
+	return all, nil
+}
+
+// newRoute returns the route of the binding of the method.
+func newRoute(file *gateway.File, s *gateway.Service, m *gateway.Method, b *gateway.Binding) *Route {
+	r := &Route{
+		Verb:       b.HTTPMethod,
+		Path:       b.PathTmpl.Template,
+		Method:     fmt.Sprintf("/%s/%s", strings.TrimPrefix(s.FQSN(), "."), m.GetName()),
+		Additional: b.Index > 0,
+		File:       file.FileDescriptorProto,
+		Service:    s.ServiceDescriptorProto,
+		Descriptor: m.MethodDescriptorProto,
+		Binding:    b,
+	}
+	if b.Body != nil {
+		r.Body = "*"
+		if len(b.Body.FieldPath) > 0 {
+			r.Body = b.Body.FieldPath.String()
+		}
+	}
+	if b.ResponseBody != nil {
+		r.ResponseBody = b.ResponseBody.FieldPath.String()
+	}
+	for _, p := range b.PathParams {
+		r.PathParams = append(r.PathParams, p.FieldPath.String())
+	}
+	return r
+}
+
+// verbOrder returns the sort order of the HTTP verb, such that the common
+// verbs are sorted in the order GET, POST, PUT, PATCH, DELETE followed by any
+// custom verbs.
+func verbOrder(verb string) int {
+	for i, v := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		if v == verb {
+			return i
+		}
+	}
+	return 5
+}
+
+// pathPart is a literal or a variable of a path template, see
+// splitPathTemplate.
+type pathPart struct {
+	// literal is the text of a literal part, e.g. "/v1/".
+	literal string
+
+	// variable tells if the part is a variable, e.g. "{name=shelves/*}", in
+	// which case fieldPath is the path of the field it binds (e.g. "name")
+	// and pattern is its pattern (e.g. "shelves/*"), or empty if it has none.
+	variable           bool
+	fieldPath, pattern string
+}
+
+// splitPathTemplate splits the path template into its literals and variables,
+// e.g. "/v1/", "{name=shelves/*}" and ":cancel" for
+// "/v1/{name=shelves/*}:cancel". An unterminated variable is a literal.
+func splitPathTemplate(tmpl string) []pathPart {
+	var parts []pathPart
+	for {
+		i := strings.Index(tmpl, "{")
+		if i < 0 {
+			break
+		}
+		j := strings.Index(tmpl[i:], "}")
+		if j < 0 {
+			break
+		}
+		if i > 0 {
+			parts = append(parts, pathPart{literal: tmpl[:i]})
+		}
+		v := pathPart{variable: true, fieldPath: tmpl[i+1 : i+j]}
+		if eq := strings.Index(v.fieldPath, "="); eq >= 0 {
+			v.fieldPath, v.pattern = v.fieldPath[:eq], v.fieldPath[eq+1:]
+		}
+		parts = append(parts, v)
+		tmpl = tmpl[i+j+1:]
+	}
+	if tmpl != "" {
+		parts = append(parts, pathPart{literal: tmpl})
+	}
+	return parts
+}
+
+// pathPattern returns the path template with its variables replaced by their
+// patterns, e.g. "/v1/shelves/*/books/**" for
+// "/v1/{shelf=shelves/*}/books/{book=**}", such that equivalent templates
+// have the same pattern.
+func pathPattern(tmpl string) string {
+	var buf strings.Builder
+	for _, p := range splitPathTemplate(tmpl) {
+		switch {
+		case !p.variable:
+			buf.WriteString(p.literal)
+		case p.pattern == "":
+			buf.WriteString("*")
+		default:
+			buf.WriteString(p.pattern)
+		}
+	}
+	return buf.String()
+}
+
+// pathPrefix returns the leading literal segments of the path template, e.g.
+// "/v1/shelves" for "/v1/shelves/{shelf}/books" or "/v1/shelves:batchGet".
+func pathPrefix(tmpl string) string {
+	var prefix []string
+	for _, seg := range strings.Split(strings.TrimPrefix(tmpl, "/"), "/") {
+		if i := strings.Index(seg, ":"); i >= 0 {
+			seg = seg[:i]
+		}
+		if seg == "" || strings.ContainsAny(seg, "{*") {
+			break
+		}
+		prefix = append(prefix, seg)
+	}
+	return "/" + strings.Join(prefix, "/")
+}
+
+// GroupRoutes groups the routes by their path prefix (see RouteGroup), returning
+// the groups sorted by prefix.
+func GroupRoutes(routes []*Route) []*RouteGroup {
+	var (
+		groups   []*RouteGroup
+		byPrefix = make(map[string]*RouteGroup)
+	)
+	for _, r := range routes {
+		prefix := pathPrefix(r.Path)
+		g, ok := byPrefix[prefix]
+		if !ok {
+			g = &RouteGroup{Prefix: prefix}
+			byPrefix[prefix] = g
+			groups = append(groups, g)
+		}
+		g.Routes = append(g.Routes, r)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Prefix < groups[j].Prefix
+	})
+	return groups
+}
+
+// routes returns the HTTP routes of the request, see Generator.Routes.
+func (f *tmplFuncs) routes() ([]*Route, error) {
+	return routes(f.registry, f.request)
+}
+
+// routeGroups returns the HTTP routes of the request grouped by their path
+// prefix, see GroupRoutes.
+func (f *tmplFuncs) routeGroups() ([]*RouteGroup, error) {
+	all, err := f.routes()
+	if err != nil {
+		return nil, err
+	}
+	return GroupRoutes(all), nil
+}
+
+// binding returns the grpc-gateway binding of the *Route or *gateway.Binding.
+func binding(v interface{}) (*gateway.Binding, error) {
+	switch t := v.(type) {
+	case *Route:
+		return t.Binding, nil
+	case *gateway.Binding:
+		return t, nil
+	}
+	return nil, fmt.Errorf("expected a route or binding, got %T", v)
+}
+
+// gatewayRule renders the HTTP method and path template (see gatewayPath) of
+// the *Route or *gateway.Binding, e.g. "POST /v1/{parent=shelves/*}/books".
+func (f *tmplFuncs) gatewayRule(v interface{}) (template.HTML, error) {
+	b, err := binding(v)
+	if err != nil {
+		return "", err
+	}
+	path := f.gatewayPath(&b.PathTmpl, b.Method.MethodDescriptorProto)
+	return template.HTML(template.HTMLEscapeString(b.HTTPMethod)) + " " + path, nil
+}
+
+// gatewayBody renders the request body selector of the *Route or
+// *gateway.Binding as a link to the input field (or type, for "*"), or returns
+// an empty string if the request has no body.
+func (f *tmplFuncs) gatewayBody(v interface{}) (template.HTML, error) {
+	b, err := binding(v)
+	if err != nil {
+		return "", err
+	}
+	if b.Body == nil {
+		return "", nil
+	}
+	input := b.Method.GetInputType()
+	if len(b.Body.FieldPath) == 0 {
+		return f.link(f.urlToType(input), "*"), nil
+	}
+	fieldPath := b.Body.FieldPath.String()
+	return f.link(f.fieldURL(input, fieldPath), fieldPath), nil
+}
+
+// gatewayResponseBody renders the response body selector of the *Route or
+// *gateway.Binding as a link to the output field, or to the output type if
+// the whole response is the body.
+func (f *tmplFuncs) gatewayResponseBody(v interface{}) (template.HTML, error) {
+	b, err := binding(v)
+	if err != nil {
+		return "", err
+	}
+	output := b.Method.GetOutputType()
+	if b.ResponseBody == nil || len(b.ResponseBody.FieldPath) == 0 {
+		return f.link(f.urlToType(output), f.cleanType(output)), nil
+	}
+	fieldPath := b.ResponseBody.FieldPath.String()
+	return f.link(f.fieldURL(output, fieldPath), fieldPath), nil
+}
+
+// link renders a link with the given URL and text, or just the text if the URL
+// is empty.
+func (f *tmplFuncs) link(url, text string) template.HTML {
+	if url == "" {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+	return template.HTML(fmt.Sprintf(`<a href="%s">%s</a>`,
+		template.HTMLEscapeString(url), template.HTMLEscapeString(text)))
+}
+
+// fieldURL returns a URL to the documentation of the field with the given
+// (dot-separated) path in the message type, e.g. "pkg.html#Type.field" for the
+// path "sub.field" of ".pkg.Outer" where Outer.sub is of type ".pkg.Type".
+// Templates are expected to give fields such anchors (see tmpl.html). If the
+// field cannot be found (or is documented externally, see Generator.Links),
+// the URL of the message type itself is returned.
+func (f *tmplFuncs) fieldURL(msgType, fieldPath string) string {
+	typ := msgType
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		msg, ok := f.symbol(typ).(*descriptor.DescriptorProto)
+		if _, external := f.externalURL(typ); !ok || external {
+			break
+		}
+		var field *descriptor.FieldDescriptorProto
+		for _, fd := range msg.Field {
+			if fd.GetName() == name {
+				field = fd
+				break
+			}
+		}
+		if field == nil {
+			break
+		}
+		if i == len(names)-1 {
+			if u := f.urlToType(typ); u != "" {
+				return u + "." + name
+			}
+			break
+		}
+		typ = field.GetTypeName()
+	}
+	return f.urlToType(msgType)
+}
@@ -0,0 +1,223 @@
+package tmpl
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+// routesRequest returns a request for a library service with HTTP bindings.
+func routesRequest(t *testing.T) *plugin.CodeGeneratorRequest {
+	str := func(name string, number int32) *descriptor.FieldDescriptorProto {
+		return &descriptor.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+	}
+	msg := func(name string, fields ...*descriptor.FieldDescriptorProto) *descriptor.DescriptorProto {
+		return &descriptor.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	method := func(name, in, out string, rule *annotations.HttpRule) *descriptor.MethodDescriptorProto {
+		options := &descriptor.MethodOptions{}
+		if err := proto.SetExtension(options, annotations.E_Http, rule); err != nil {
+			t.Fatal(err)
+		}
+		return &descriptor.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".lib." + in),
+			OutputType: proto.String(".lib." + out),
+			Options:    options,
+		}
+	}
+	book := str("book", 2)
+	book.Type = descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	book.TypeName = proto.String(".lib.Book")
+	shelves := str("shelves", 1)
+	shelves.Type = descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	shelves.TypeName = proto.String(".lib.Shelf")
+
+	get := func(path string) *annotations.HttpRule {
+		return &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: path}}
+	}
+	return &plugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"lib.proto"},
+		ProtoFile: []*descriptor.FileDescriptorProto{{
+			Name:    proto.String("lib.proto"),
+			Package: proto.String("lib"),
+			MessageType: []*descriptor.DescriptorProto{
+				msg("Shelf", str("name", 1)),
+				msg("Book", str("name", 1)),
+				msg("GetShelfRequest", str("name", 1)),
+				msg("ListShelvesRequest"),
+				msg("ListShelvesResponse", shelves),
+				msg("CreateBookRequest", str("shelf", 1), book),
+			},
+			Service: []*descriptor.ServiceDescriptorProto{{
+				Name: proto.String("Library"),
+				Method: []*descriptor.MethodDescriptorProto{
+					method("GetShelf", "GetShelfRequest", "Shelf", get("/v1/{name=shelves/*}")),
+					method("ListShelves", "ListShelvesRequest", "ListShelvesResponse", &annotations.HttpRule{
+						Pattern:      &annotations.HttpRule_Get{Get: "/v1/shelves"},
+						ResponseBody: "shelves",
+					}),
+					method("CreateBook", "CreateBookRequest", "Book", &annotations.HttpRule{
+						Pattern: &annotations.HttpRule_Post{Post: "/v1/{shelf=shelves/*}/books"},
+						Body:    "book",
+						AdditionalBindings: []*annotations.HttpRule{{
+							Pattern: &annotations.HttpRule_Post{Post: "/v2/books"},
+							Body:    "*",
+						}},
+					}),
+					method("LegacyGetShelf", "GetShelfRequest", "Shelf", get("/v1/shelves/{name}")),
+				},
+			}},
+		}},
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	g := New()
+	if err := g.SetRequest(routesRequest(t)); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := g.Routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range routes {
+		s := r.String() + " " + r.Method
+		if r.Body != "" {
+			s += " body=" + r.Body
+		}
+		if r.ResponseBody != "" {
+			s += " response=" + r.ResponseBody
+		}
+		if len(r.PathParams) > 0 {
+			s += " params=" + strings.Join(r.PathParams, ",")
+		}
+		if r.Additional {
+			s += " additional"
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"GET /v1/shelves /lib.Library/ListShelves response=shelves",
+		"GET /v1/shelves/{name} /lib.Library/LegacyGetShelf params=name",
+		"GET /v1/{name=shelves/*} /lib.Library/GetShelf params=name",
+		"POST /v1/{shelf=shelves/*}/books /lib.Library/CreateBook body=book params=shelf",
+		"POST /v2/books /lib.Library/CreateBook body=* additional",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got routes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// GetShelf and LegacyGetShelf have equivalent paths.
+	if len(routes[1].Conflicts) != 1 || routes[1].Conflicts[0] != routes[2] || len(routes[2].Conflicts) != 1 {
+		t.Fatalf("expected GetShelf and LegacyGetShelf to conflict")
+	}
+	for _, i := range []int{0, 3, 4} {
+		if len(routes[i].Conflicts) != 0 {
+			t.Fatalf("unexpected conflicts for %s", routes[i])
+		}
+	}
+
+	// The routes are grouped by path prefix in templates.
+	f := &tmplFuncs{registry: g.registry, request: g.request}
+	tp := template.Must(template.New("").Funcs(f.funcMap()).Parse(
+		`{{range routeGroups}}{{.Prefix}}:{{range .Routes}} {{.Verb}}{{end}};{{end}}`,
+	))
+	var buf bytes.Buffer
+	if err := tp.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "/v1: GET POST;/v1/shelves: GET GET;/v2/books: POST;"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestSplitPathTemplate(t *testing.T) {
+	got := splitPathTemplate("/v1/{name=shelves/*}/books/{book}:cancel{")
+	want := []pathPart{
+		{literal: "/v1/"},
+		{variable: true, fieldPath: "name", pattern: "shelves/*"},
+		{literal: "/books/"},
+		{variable: true, fieldPath: "book"},
+		{literal: ":cancel{"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+}
+
+func TestPathPattern(t *testing.T) {
+	tests := map[string]string{
+		"/v1/shelves":                          "/v1/shelves",
+		"/v1/{name}":                           "/v1/*",
+		"/v1/{name=shelves/*}/books/{book=**}": "/v1/shelves/*/books/**",
+		"/v1/{name}:publish":                   "/v1/*:publish",
+	}
+	for tmpl, want := range tests {
+		if got := pathPattern(tmpl); got != want {
+			t.Fatalf("%s: got %q want %q", tmpl, got, want)
+		}
+	}
+}
+
+func TestGatewayRendering(t *testing.T) {
+	r := routesRequest(t)
+	options := &descriptor.MethodOptions{}
+	err := proto.SetExtension(options, annotations.E_Http, &annotations.HttpRule{
+		Pattern:      &annotations.HttpRule_Patch{Patch: "/v1/{book.name=shelves/*/books/**}:cancel"},
+		Body:         "book",
+		ResponseBody: "name",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := r.ProtoFile[0].Service[0]
+	svc.Method = append(svc.Method, &descriptor.MethodDescriptorProto{
+		Name:       proto.String("CancelBook"),
+		InputType:  proto.String(".lib.CreateBookRequest"),
+		OutputType: proto.String(".lib.Book"),
+		Options:    options,
+	})
+	g := New()
+	if err := g.SetRequest(r); err != nil {
+		t.Fatal(err)
+	}
+	f := &tmplFuncs{
+		protoFile:  r.ProtoFile,
+		registry:   g.registry,
+		request:    g.request,
+		outputFile: "lib.html",
+		apiHost:    "https://api.example.com/",
+	}
+	tp := template.Must(template.New("").Funcs(f.funcMap()).Parse(
+		`{{range routes}}{{gatewayRule .}} [{{gatewayBody .}}] [{{gatewayResponseBody .}}]` + "\n" + `{{end}}`,
+	))
+	var buf bytes.Buffer
+	if err := tp.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`GET https://api.example.com/v1/shelves [] [<a href="lib.html#ListShelvesResponse.shelves">shelves</a>]`,
+		`GET https://api.example.com/v1/shelves/<a href="lib.html#GetShelfRequest.name">{name}</a> [] [<a href="lib.html#Shelf">Shelf</a>]`,
+		`PATCH https://api.example.com/v1/<a href="lib.html#Book.name">{book.name=shelves/*/books/**}</a>:cancel [<a href="lib.html#CreateBookRequest.book">book</a>] [<a href="lib.html#Book.name">name</a>]`,
+		`GET https://api.example.com/v1/<a href="lib.html#GetShelfRequest.name">{name=shelves/*}</a> [] [<a href="lib.html#Shelf">Shelf</a>]`,
+		`POST https://api.example.com/v1/<a href="lib.html#CreateBookRequest.shelf">{shelf=shelves/*}</a>/books [<a href="lib.html#CreateBookRequest.book">book</a>] [<a href="lib.html#Book">Book</a>]`,
+		`POST https://api.example.com/v2/books [<a href="lib.html#CreateBookRequest">*</a>] [<a href="lib.html#Book">Book</a>]`,
+	}
+	if got := strings.TrimSpace(buf.String()); got != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
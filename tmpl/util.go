@@ -15,6 +15,7 @@ import (
 
 	gateway "github.com/grpc-ecosystem/grpc-gateway/protoc-gen-grpc-gateway/descriptor"
 	"github.com/grpc-ecosystem/grpc-gateway/protoc-gen-grpc-gateway/httprule"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
 	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"sourcegraph.com/sourcegraph/prototools/util"
 )
@@ -93,6 +94,24 @@ func comments(c string) []string {
 	return segments
 }
 
+// packagePath returns the unix-style path for the given protobuf package name,
+// e.g. "foo.bar" -> "foo/bar".
+func packagePath(pkg string) string {
+	return strings.Replace(pkg, ".", "/", -1)
+}
+
+// symbolPath returns the fully-qualified symbol path of the named symbol
+// declared inside the given file, e.g. for a file declaring "package pkg;":
+//
+//  symbolPath(f, "Outer", "Inner") == ".pkg.Outer.Inner"
+//
+func symbolPath(f *descriptor.FileDescriptorProto, names ...string) string {
+	if pkg := f.GetPackage(); len(pkg) > 0 {
+		names = append([]string{pkg}, names...)
+	}
+	return "." + strings.Join(names, ".")
+}
+
 var Preload = (&tmplFuncs{}).funcMap()
 
 // cacheItem is a single cache item with a value and a location -- effectively
@@ -107,17 +126,34 @@ type cacheItem struct {
 // closures with context).
 type tmplFuncs struct {
 	f                   *descriptor.FileDescriptorProto
+	pkg                 *util.Package
 	outputFile, rootDir string
 	protoFile           []*descriptor.FileDescriptorProto
 	registry            *gateway.Registry
 	apiHost             string
+	apiHosts            []*Host
+	links               map[string]string
+	layout              Layout
+	params              map[string]string
+	request             *plugin.CodeGeneratorRequest
+	gen                 *FileMapGenerate
+
+	// funcs are the custom functions of the generator (see Generator.Funcs).
+	funcs template.FuncMap
+
+	// symbols indexes the messages, enums and extensions of protoFile by their
+	// fully-qualified names, see symbol.
+	symbols map[string]interface{}
+
+	// resolver resolves the symbols of protoFile, see symbolResolver.
+	resolver *util.Resolver
 
 	locCache []cacheItem
 }
 
 // funcMap returns the function map for feeding into templates.
 func (f *tmplFuncs) funcMap() template.FuncMap {
-	return map[string]interface{}{
+	funcMap := map[string]interface{}{
 		"cleanLabel": f.cleanLabel,
 		"cleanType":  f.cleanType,
 		"fieldType":  f.fieldType,
@@ -127,25 +163,89 @@ func (f *tmplFuncs) funcMap() template.FuncMap {
 			dir, _ := path.Split(s)
 			return dir
 		},
-		"trimExt":       stripExt,
-		"slug":          slug,
-		"comments":      comments,
-		"sub":           f.sub,
-		"filepath":      f.filepath,
-		"gatewayMethod": f.gatewayMethod,
-		"gatewayPath":   f.gatewayPath,
-		"urlToType":     f.urlToType,
-		"jsonMessage":   f.jsonMessage,
-		"location":      f.location,
-		"AllMessages": func(fixNames bool) []*descriptor.DescriptorProto {
-			return util.AllMessages(f.f, fixNames)
-		},
-		"AllEnums": func(fixNames bool) []*descriptor.EnumDescriptorProto {
-			return util.AllEnums(f.f, fixNames)
-		},
+		"trimExt":             stripExt,
+		"slug":                slug,
+		"packages":            util.Packages,
+		"packagePath":         packagePath,
+		"symbolPath":          symbolPath,
+		"comments":            comments,
+		"filepath":            f.filepath,
+		"gatewayMethod":       f.gatewayMethod,
+		"gatewayPath":         f.gatewayPath,
+		"gatewayRule":         f.gatewayRule,
+		"gatewayBody":         f.gatewayBody,
+		"gatewayResponseBody": f.gatewayResponseBody,
+		"hosts":               f.hosts,
+		"host":                f.host,
+		"curlExample":         f.curlExample,
+		"httpExample":         f.httpExample,
+		"urlToType":           f.urlToType,
+		"jsonMessage":         f.jsonMessage,
+		"location":            f.location,
+		"AllMessages":         f.allMessages,
+		"AllEnums":            f.allEnums,
+		"param":               f.param,
+		"hasParam":            f.hasParam,
+		"option":              f.option,
+		"withOption":          f.withOption,
+		"withoutOption":       f.withoutOption,
+		"routes":              f.routes,
+		"routeGroups":         f.routeGroups,
+		"openAPI":             f.openAPI,
+	}
+	for name, fn := range stdFuncs {
+		funcMap[name] = fn
+	}
+	for name, fn := range descriptorFuncs {
+		funcMap[name] = fn
+	}
+	if len(f.funcs) > 0 {
+		for name, fn := range bindFuncs(f.funcs, f.context()) {
+			funcMap[name] = fn
+		}
+	}
+	return funcMap
+}
+
+// files returns the proto files that the template is being executed for: the
+// target file, the files of the target package, or none at all.
+func (f *tmplFuncs) files() []*descriptor.FileDescriptorProto {
+	switch {
+	case f.f != nil:
+		return []*descriptor.FileDescriptorProto{f.f}
+	case f.pkg != nil:
+		return f.pkg.File
+	default:
+		return nil
 	}
 }
 
+// allMessages returns all of the messages (see util.AllMessages) in the given
+// files, or in the files the template is being executed for if none are given.
+func (f *tmplFuncs) allMessages(fixNames bool, files ...*descriptor.FileDescriptorProto) []*descriptor.DescriptorProto {
+	if len(files) == 0 {
+		files = f.files()
+	}
+	var all []*descriptor.DescriptorProto
+	for _, file := range files {
+		all = append(all, util.AllMessages(file, fixNames)...)
+	}
+	return all
+}
+
+// allEnums returns all of the enums (see util.AllEnums) in the given files, or
+// in the files the template is being executed for if none are given.
+func (f *tmplFuncs) allEnums(fixNames bool, files ...*descriptor.FileDescriptorProto) []*descriptor.EnumDescriptorProto {
+	if len(files) == 0 {
+		files = f.files()
+	}
+	var all []*descriptor.EnumDescriptorProto
+	for _, file := range files {
+		all = append(all, util.AllEnums(file, fixNames)...)
+	}
+	return all
+}
+
 // cleanLabel returns the clean (i.e. human-readable / protobuf-style) version
 // of a label.
 func (f *tmplFuncs) cleanLabel(l *descriptor.FieldDescriptorProto_Label) string {
@@ -190,8 +290,15 @@ func (f *tmplFuncs) dict(pairs ...interface{}) (map[string]interface{}, error) {
 	return m, nil
 }
 
-// sub performs simple x-y subtraction on integers.
-func (f *tmplFuncs) sub(x, y int) int { return x - y }
+// param returns the value of the named plugin parameter, or an empty string if
+// it is not set.
+func (f *tmplFuncs) param(name string) string { return f.params[name] }
+
+// hasParam tells if the named plugin parameter is set (even to an empty value).
+func (f *tmplFuncs) hasParam(name string) bool {
+	_, ok := f.params[name]
+	return ok
+}
 
 // filepath returns the output filepath (prefixed by the root directory).
 func (f *tmplFuncs) filepath() string {
@@ -200,14 +307,16 @@ func (f *tmplFuncs) filepath() string {
 
 // gatewayMethod returns the grpc-gateway method for a given service method.
 func (f *tmplFuncs) gatewayMethod(target *descriptor.MethodDescriptorProto) (*gateway.Method, error) {
-	file, err := f.registry.LookupFile(f.f.GetName())
-	if err != nil {
-		return nil, err
-	}
-	for _, s := range file.Services {
-		for _, m := range s.Methods {
-			if m.MethodDescriptorProto == target {
-				return m, nil
+	for _, pf := range f.files() {
+		file, err := f.registry.LookupFile(pf.GetName())
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range file.Services {
+			for _, m := range s.Methods {
+				if m.MethodDescriptorProto == target {
+					return m, nil
+				}
 			}
 		}
 	}
@@ -215,30 +324,34 @@ func (f *tmplFuncs) gatewayMethod(target *descriptor.MethodDescriptorProto) (*ga
 }
 
 // gatewayPath renders the given grpc-gateway HTTP rule template (i.e. the HTTP
-// route to be bound). The method parameter is used to insert a link to the
-// method type for any HTTP fields (which will be marked clearly in "{text}").
+// route to be bound) as written, e.g. "/v1/{name=projects/*/items/*}:cancel".
+// Each variable (which will be marked clearly in "{text}") links to the field
+// of the method's input type that it binds, see fieldURL.
 //
 // The returned string will always be prefixed by the APIHost string.
 func (f *tmplFuncs) gatewayPath(r *httprule.Template, method *descriptor.MethodDescriptorProto) template.HTML {
-	var final string
-pool:
-	for _, pathElem := range r.Pool {
-		for _, fieldName := range r.Fields {
-			if pathElem != fieldName {
-				continue
-			}
-			u := fmt.Sprintf(`<a href="%s">{%s}</a>`, f.urlToType(method.GetInputType()), pathElem)
-			final = path.Join(final, u)
-			continue pool
+	final := strings.TrimSuffix(f.apiHost, "/")
+	for _, p := range splitPathTemplate(r.Template) {
+		if !p.variable {
+			final += template.HTMLEscapeString(p.literal)
+			continue
 		}
-		final = path.Join(final, pathElem)
+		variable := p.fieldPath
+		if p.pattern != "" {
+			variable += "=" + p.pattern
+		}
+		final += fmt.Sprintf(`<a href="%s">{%s}</a>`,
+			template.HTMLEscapeString(f.fieldURL(method.GetInputType(), p.fieldPath)),
+			template.HTMLEscapeString(variable),
+		)
 	}
-	return template.HTML(f.apiHost + final)
+	return template.HTML(final)
 }
 
-// urlToType returns a URL to the documentation file for the given type. The
-// input type path can be either fully-qualified or not, regardless, the URL
-// returned will always have a fully-qualified hash.
+// urlToType returns a URL to the documentation file for the given type (or to
+// its external documentation, see Generator.Links). The input type path can be
+// either fully-qualified or not, regardless, the URL returned will always have
+// a fully-qualified hash.
 //
 // TODO(slimsag): have the template pass in the relative type instead of nil,
 // so that relative symbol paths work.
@@ -247,8 +360,13 @@ func (f *tmplFuncs) urlToType(symbolPath string) string {
 		panic("urlToType: not a fully-qualified symbol path")
 	}
 
+	// Types may be documented externally, see Generator.Links.
+	if url, ok := f.externalURL(symbolPath); ok {
+		return url
+	}
+
 	// Resolve the package path for the type.
-	file := util.NewResolver(f.protoFile).ResolveFile(symbolPath, nil)
+	file := f.symbolResolver().ResolveFile(symbolPath, nil)
 	if file == nil {
 		return ""
 	}
@@ -263,8 +381,12 @@ func (f *tmplFuncs) urlToType(symbolPath string) string {
 	typePath := util.TrimElem(symbolPath, util.CountElem(file.GetPackage()))
 
 	// Prefix the absolute path with the root directory and swap the extension out
-	// with the correct one.
+	// with the correct one. In the package layout, the package path is used in
+	// place of the file path.
 	p := stripExt(pkgPath) + path.Ext(f.outputFile)
+	if f.layout == PackageLayout {
+		p = packagePath(util.PackageName(file)) + path.Ext(f.outputFile)
+	}
 	p = path.Join(f.rootDir, p)
 	return fmt.Sprintf("%s#%s", p, typePath)
 }
@@ -304,11 +426,13 @@ func (f *tmplFuncs) location(x interface{}) *descriptor.SourceCodeInfo_Location
 
 	// If the location cache is empty; we build it now.
 	if f.locCache == nil {
-		for _, loc := range f.f.SourceCodeInfo.Location {
-			f.locCache = append(f.locCache, cacheItem{
-				V: f.walkPath(loc.Path),
-				L: loc,
-			})
+		for _, file := range f.files() {
+			for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+				f.locCache = append(f.locCache, cacheItem{
+					V: f.walkPath(file, loc.Path),
+					L: loc,
+				})
+			}
 		}
 	}
 	return f.findCachedItem(x)
@@ -324,11 +448,11 @@ func (f *tmplFuncs) findCachedItem(x interface{}) *descriptor.SourceCodeInfo_Loc
 	return nil
 }
 
-// walkPath walks through the root node (the f.f file) descending down the path
-// until it is resolved, at which point the value is returned.
-func (f *tmplFuncs) walkPath(path []int32) interface{} {
+// walkPath walks through the root file node descending down the path until it
+// is resolved, at which point the value is returned.
+func (f *tmplFuncs) walkPath(root *descriptor.FileDescriptorProto, path []int32) interface{} {
 	if len(path) == 0 {
-		return f.f
+		return root
 	}
 	var (
 		walker func(id int, v interface{}) bool
@@ -349,7 +473,7 @@ func (f *tmplFuncs) walkPath(path []int32) interface{} {
 		f.protoFields(reflect.ValueOf(v), walker)
 		return false
 	}
-	f.protoFields(reflect.ValueOf(f.f), walker)
+	f.protoFields(reflect.ValueOf(root), walker)
 	return found
 }
 
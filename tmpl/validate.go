@@ -0,0 +1,391 @@
+package tmpl
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/prototools/util"
+)
+
+// FileMapError is a single error found while validating a filemap.
+type FileMapError struct {
+	// File is the path of the imported filemap the error refers to, or an
+	// empty string for the filemap itself.
+	File string
+
+	// Line and Column are the position (starting at 1) of the element the
+	// error refers to, inside the executed filemap template. They are zero if
+	// the position is not known (e.g. for YAML and JSON filemaps, only decoding
+	// errors have a position).
+	Line, Column int
+
+	// Msg is the error message.
+	Msg string
+}
+
+// Error implements the error interface.
+func (e *FileMapError) Error() string {
+	var prefix string
+	if e.File != "" {
+		prefix = e.File + ":"
+	}
+	if e.Line == 0 {
+		if prefix != "" {
+			prefix += " "
+		}
+		return prefix + e.Msg
+	}
+	return fmt.Sprintf("%s%d:%d: %s", prefix, e.Line, e.Column, e.Msg)
+}
+
+// FileMapErrors is a list of errors found while validating a filemap, sorted
+// by their position.
+type FileMapErrors []*FileMapError
+
+// Error implements the error interface, returning each error on its own line.
+func (e FileMapErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// xmlNode is a single element of a parsed XML document, with its position.
+type xmlNode struct {
+	file         string // see FileMapError.File
+	name         string
+	line, column int
+	text         string
+	children     []*xmlNode
+}
+
+// child returns the first child node with the given name, or nil. It is safe
+// to call on a nil node.
+func (n *xmlNode) child(name string) *xmlNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// path returns all of the descendant nodes matching the given path of element
+// names, e.g. path("Data", "Item"). It is safe to call on a nil node.
+func (n *xmlNode) path(names ...string) []*xmlNode {
+	if n == nil {
+		return nil
+	}
+	if len(names) == 0 {
+		return []*xmlNode{n}
+	}
+	var found []*xmlNode
+	for _, c := range n.children {
+		if c.name == names[0] {
+			found = append(found, c.path(names[1:]...)...)
+		}
+	}
+	return found
+}
+
+// position returns the position of the node, or zero if the node is nil.
+func (n *xmlNode) position() (line, column int) {
+	if n == nil {
+		return 0, 0
+	}
+	return n.line, n.column
+}
+
+// definedAt returns a " (first defined at line:column)" string for use in
+// error messages, or an empty string if the node is nil.
+func (n *xmlNode) definedAt() string {
+	if n == nil {
+		return ""
+	}
+	if n.file != "" {
+		return fmt.Sprintf(" (first defined at %s:%d:%d)", n.file, n.line, n.column)
+	}
+	return fmt.Sprintf(" (first defined at %d:%d)", n.line, n.column)
+}
+
+// nthNode returns the nth node in the list, or nil if there is no such node.
+func nthNode(nodes []*xmlNode, n int) *xmlNode {
+	if n < len(nodes) {
+		return nodes[n]
+	}
+	return nil
+}
+
+// parseXMLNodes parses the XML document into a tree of nodes, returning the
+// root element. The file is recorded on each node (see FileMapError.File).
+func parseXMLNodes(file string, data []byte) (*xmlNode, error) {
+	var (
+		d     = xml.NewDecoder(bytes.NewReader(data))
+		root  = &xmlNode{}
+		stack = []*xmlNode{root}
+	)
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if serr, ok := err.(*xml.SyntaxError); ok {
+				return nil, FileMapErrors{{File: file, Line: serr.Line, Column: 1, Msg: serr.Msg}}
+			}
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			line, column := position(data, offset)
+			n := &xmlNode{file: file, name: t.Name.Local, line: line, column: column}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			top.text += string(t)
+		}
+	}
+	if len(root.children) == 0 {
+		return nil, FileMapErrors{{File: file, Line: 1, Column: 1, Msg: "no root element found in file map"}}
+	}
+	return root.children[0], nil
+}
+
+// position returns the line and column (starting at 1) of the byte offset
+// inside data.
+func position(data []byte, offset int64) (line, column int) {
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// xmlSchema describes which child elements an XML element may have, as derived
+// from the Go type it unmarshals into by xmlSchemaOf.
+type xmlSchema struct {
+	// any is whether or not any child element is allowed.
+	any bool
+
+	// children is a map of allowed child element names to their schemas.
+	children map[string]*xmlSchema
+}
+
+// xmlSchemaOf returns the schema of elements that encoding/xml would unmarshal
+// into the given Go type.
+func xmlSchemaOf(t reflect.Type) *xmlSchema {
+	return xmlSchemaOfType(t, make(map[reflect.Type]*xmlSchema))
+}
+
+// xmlSchemaOfType implements xmlSchemaOf, seen is a map of the schemas of
+// struct types already seen (so that recursive types are handled).
+func xmlSchemaOfType(t reflect.Type, seen map[reflect.Type]*xmlSchema) *xmlSchema {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if s, ok := seen[t]; ok {
+		return s
+	}
+	s := &xmlSchema{children: make(map[string]*xmlSchema)}
+	if t.Kind() != reflect.Struct {
+		return s
+	}
+	seen[t] = s
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		tag := field.Tag.Get("xml")
+		if tag == "-" {
+			continue
+		}
+		split := strings.Split(tag, ",")
+		name, skip := split[0], false
+		for _, flag := range split[1:] {
+			switch flag {
+			case "any", "innerxml":
+				s.any = true
+				skip = true
+			case "attr", "chardata", "cdata", "comment":
+				skip = true
+			}
+		}
+		if skip {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		// Handle parent>child style paths.
+		parent := s
+		elems := strings.Split(name, ">")
+		for _, elem := range elems[:len(elems)-1] {
+			next, ok := parent.children[elem]
+			if !ok {
+				next = &xmlSchema{children: make(map[string]*xmlSchema)}
+				parent.children[elem] = next
+			}
+			parent = next
+		}
+		parent.children[elems[len(elems)-1]] = xmlSchemaOfType(field.Type, seen)
+	}
+	return s
+}
+
+// validate validates the filemap (g.FileMap), which must already have been
+// loaded (see loadFileMap), along with the errors already found while loading
+// it. Errors are positioned at the element that each generator was declared
+// by, if known. If any problems are found a FileMapErrors list is returned.
+func (g *Generator) validate(errs FileMapErrors) error {
+	report := func(n *xmlNode, format string, args ...interface{}) {
+		var file string
+		if n != nil {
+			file = n.file
+		}
+		line, column := n.position()
+		errs = append(errs, &FileMapError{
+			File:   file,
+			Line:   line,
+			Column: column,
+			Msg:    fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Gather the names of the input proto files and packages.
+	var (
+		protoFiles = make(map[string]bool)
+		packages   = make(map[string]bool)
+	)
+	for _, f := range g.request.GetProtoFile() {
+		protoFiles[f.GetName()] = true
+		packages[util.PackageName(f)] = true
+	}
+
+	outputs := make(map[string]*xmlNode)
+	for _, gen := range g.FileMap.Generate {
+		n := gen.node
+
+		// Check for duplicate outputs.
+		if gen.Output == "" {
+			report(n, "missing output for generator")
+		} else if first, ok := outputs[gen.Output]; ok {
+			report(n.child("Output"), "duplicate output %q%s", gen.Output, first.definedAt())
+		} else {
+			outputs[gen.Output] = n.child("Output")
+		}
+
+		// Check for unknown targets.
+		if gen.Target != "" && !protoFiles[gen.Target] {
+			report(n.child("Target"), "unknown target %q (no such input proto file)", gen.Target)
+		}
+		if gen.Package != "" && !packages[gen.Package] {
+			report(n.child("Package"), "unknown package %q (no such input proto package)", gen.Package)
+		}
+		if gen.Symbol != "" {
+			if _, _, _, err := g.lookupSymbol(gen.Symbol); err != nil {
+				report(n.child("Symbol"), "%s", err)
+			}
+		}
+		targets := 0
+		for _, t := range []string{gen.Target, gen.Package, gen.Symbol} {
+			if t != "" {
+				targets++
+			}
+		}
+		if targets > 1 {
+			report(n, "generator may have only one of a target, package, or symbol")
+		}
+
+		// Check for invalid conditions.
+		if gen.When != "" {
+			if _, err := g.condTemplate(gen.When); err != nil {
+				report(n.child("When"), "bad condition: %s", err)
+			}
+		}
+
+		// Check for missing templates and includes.
+		if gen.Template == "" {
+			report(n, "missing template for generator")
+		} else if _, _, err := g.findFile(g.searchDirs(gen), gen.Template); err != nil {
+			report(n.child("Template"), "missing template: %s", err)
+		}
+		includeNodes := n.path("Includes", "Include")
+		for j, inc := range gen.Include {
+			if _, err := g.resolveInclude(g.searchDirs(gen), inc); err != nil {
+				report(nthNode(includeNodes, j), "missing include: %s", err)
+			}
+		}
+
+		// Check for duplicate data keys.
+		var (
+			keys      = make(map[string]*xmlNode)
+			itemNodes = n.path("Data", "Item")
+		)
+		for j, item := range gen.Data {
+			keyNode := nthNode(itemNodes, j).child("Key")
+			if first, ok := keys[item.Key]; ok {
+				report(keyNode, "duplicate data key %q%s", item.Key, first.definedAt())
+				continue
+			}
+			keys[item.Key] = keyNode
+
+			// Check for invalid values and unresolvable references.
+			v, err := item.Interface()
+			if err == nil {
+				_, err = g.resolveRefs(v)
+			}
+			if err != nil {
+				report(nthNode(itemNodes, j), "invalid data item %q: %s", item.Key, err)
+			}
+		}
+		for k, v := range gen.Values {
+			if _, err := g.resolveRefs(jsonRefs(v)); err != nil {
+				report(n, "invalid data item %q: %s", k, err)
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+	return errs
+}
+
+// validateElements reports any elements below n which are not allowed by the
+// given schema, recursively.
+func validateElements(n *xmlNode, schema *xmlSchema, report func(n *xmlNode, format string, args ...interface{})) {
+	if schema.any {
+		return
+	}
+	for _, c := range n.children {
+		s, ok := schema.children[c.name]
+		if !ok {
+			report(c, "unknown element <%s> inside <%s>", c.name, n.name)
+			continue
+		}
+		validateElements(c, s, report)
+	}
+}
@@ -0,0 +1,77 @@
+package tmpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateFileMap(t *testing.T) {
+	g := testGenerator(t, map[string]string{
+		"a.html":      "",
+		"common.html": "",
+	})
+	err := g.ParseFileMap("", `<FileMap>
+    <Generate>
+        <Template>a.html</Template>
+        <Target>world/human.proto</Target>
+        <Output>a.html</Output>
+        <Includes><Include>common.html</Include></Includes>
+    </Generate>
+    <Generate>
+        <Template>missing.html</Template>
+        <Target>missing.proto</Target>
+        <Output>a.html</Output>
+        <Includes><Include>missing-include.html</Include></Includes>
+        <Data>
+            <Item><Key>k</Key><Value>1</Value></Item>
+            <Item><Key>k</Key><Value>2</Value></Item>
+        </Data>
+        <Unknown/>
+    </Generate>
+    <Generate>
+        <Template>a.html</Template>
+        <Package>missing</Package>
+        <Output>b.html</Output>
+    </Generate>
+</FileMap>
+`)
+	errs, ok := err.(FileMapErrors)
+	if !ok {
+		t.Fatalf("expected FileMapErrors, got %#v", err)
+	}
+	type pos struct{ line, column int }
+	want := []pos{
+		{9, 9},   // missing template
+		{10, 9},  // unknown target
+		{11, 9},  // duplicate output
+		{12, 19}, // missing include
+		{15, 19}, // duplicate data key
+		{17, 9},  // unknown element
+		{21, 9},  // unknown package
+	}
+	var got []pos
+	for _, e := range errs {
+		got = append(got, pos{e.Line, e.Column})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got positions %v want %v\n%s", got, want, errs)
+	}
+}
+
+func TestValidateFileMapValid(t *testing.T) {
+	g := testGenerator(t, map[string]string{"a.html": ""})
+	err := g.ParseFileMap("", `
+<FileMap>
+    {{range .ProtoFile}}
+    <Generate>
+        <Template>a.html</Template>
+        <Target>{{.Name}}</Target>
+        <Output>{{trimExt .Name}}.html</Output>
+    </Generate>
+    {{end}}
+</FileMap>
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,20 +6,35 @@ import (
 	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
 )
 
-// nameMessage names the given message descriptor, returning a copy with the
-// Name pointer replaced with &newName.
+// nameMessage names the given message descriptor, returning a shallow copy with
+// the Name pointer replaced with &newName.
 func nameMessage(old *descriptor.DescriptorProto, newName string) *descriptor.DescriptorProto {
-	cpy := *old
-	cpy.Name = &newName
-	return &cpy
+	// The fields are copied individually, as the message (internal) state must
+	// not be copied.
+	return &descriptor.DescriptorProto{
+		Name:           &newName,
+		Field:          old.Field,
+		Extension:      old.Extension,
+		NestedType:     old.NestedType,
+		EnumType:       old.EnumType,
+		ExtensionRange: old.ExtensionRange,
+		OneofDecl:      old.OneofDecl,
+		Options:        old.Options,
+		ReservedRange:  old.ReservedRange,
+		ReservedName:   old.ReservedName,
+	}
 }
 
-// nameEnum names the given enum descriptor, returning a copy with the Name0
-// pointer replaced with &newName.
+// nameEnum names the given enum descriptor, returning a shallow copy with the
+// Name pointer replaced with &newName.
 func nameEnum(old *descriptor.EnumDescriptorProto, newName string) *descriptor.EnumDescriptorProto {
-	cpy := *old
-	cpy.Name = &newName
-	return &cpy
+	return &descriptor.EnumDescriptorProto{
+		Name:          &newName,
+		Value:         old.Value,
+		Options:       old.Options,
+		ReservedRange: old.ReservedRange,
+		ReservedName:  old.ReservedName,
+	}
 }
 
 // appendEnum is a helper function for appending two symbol paths together. It's
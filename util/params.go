@@ -0,0 +1,436 @@
+package util
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Params are the plugin parameters passed to a generator by protoc, as parsed
+// by ParseParamString. A parameter may be given multiple times.
+type Params struct {
+	keys   []string
+	values map[string][]string
+}
+
+// ParseParamString parses a plugin parameter string of the form:
+//
+//  key=value,flag,key="quoted, value",key='single quoted'
+//
+// Parameters are separated by commas (or newlines, e.g. in configuration
+// files), and split into a key and value at the first '='. Whitespace around
+// keys and values is removed, except inside quotes. Double-quoted values may
+// contain \" and \\ escapes, single-quoted values are taken literally, and
+// outside of quotes a backslash escapes a following comma or quote. Quotes
+// only start a quoted string at the beginning of a key or value, so values
+// like "Bob's API" need no escaping. A key without a value (a flag) has the
+// empty value.
+//
+// The parameters parsed up to an error (e.g. an unterminated quote) are
+// returned along with it.
+func ParseParamString(s string) (*Params, error) {
+	p := &Params{values: make(map[string][]string)}
+	var (
+		key, val paramToken
+		cur      = &key
+		quote    rune
+		haveEq   bool
+		runes    = []rune(s)
+	)
+	end := func() error {
+		k, v := key.String(), val.String()
+		key, val, cur, haveEq = paramToken{}, paramToken{}, &key, false
+		if k == "" {
+			if v != "" {
+				return fmt.Errorf("missing parameter name for value %q", v)
+			}
+			return nil
+		}
+		p.Add(k, v)
+		return nil
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+				continue
+			}
+			cur.literal(r)
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+				continue
+			}
+			if r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+				r = runes[i]
+			}
+			cur.literal(r)
+		case (r == '"' || r == '\'') && !cur.started:
+			quote = r
+			cur.quoted()
+		case r == '\\' && i+1 < len(runes) && strings.ContainsRune(`,"'`, runes[i+1]):
+			i++
+			cur.literal(runes[i])
+		case r == ',' || r == '\n':
+			if err := end(); err != nil {
+				return p, err
+			}
+		case r == '=' && !haveEq:
+			haveEq, cur = true, &val
+		default:
+			cur.add(r)
+		}
+	}
+	if quote != 0 {
+		return p, fmt.Errorf("unterminated %c quote in parameter %q", quote, key.String())
+	}
+	return p, end()
+}
+
+// paramToken is a parameter key or value being parsed, whose surrounding
+// unquoted whitespace is removed.
+type paramToken struct {
+	buf strings.Builder
+
+	// keep is the length of the token up to its last non-space (or quoted)
+	// character.
+	keep int
+
+	// started is whether there was a non-space (or quoted) character.
+	started bool
+}
+
+// add adds an unquoted character to the token.
+func (t *paramToken) add(r rune) {
+	if unicode.IsSpace(r) {
+		if t.started {
+			t.buf.WriteRune(r)
+		}
+		return
+	}
+	t.literal(r)
+}
+
+// literal adds a quoted or escaped character to the token.
+func (t *paramToken) literal(r rune) {
+	t.buf.WriteRune(r)
+	t.quoted()
+}
+
+// quoted marks the current end of the token as significant, e.g. at an empty
+// quoted string.
+func (t *paramToken) quoted() {
+	t.keep, t.started = t.buf.Len(), true
+}
+
+// String returns the token without its trailing whitespace.
+func (t *paramToken) String() string {
+	return t.buf.String()[:t.keep]
+}
+
+// Add adds the value of the parameter, after any previous values.
+func (p *Params) Add(key, value string) {
+	if p.values == nil {
+		p.values = make(map[string][]string)
+	}
+	if _, ok := p.values[key]; !ok {
+		p.keys = append(p.keys, key)
+	}
+	p.values[key] = append(p.values[key], value)
+}
+
+// Set sets the value of the parameter, replacing any previous values.
+func (p *Params) Set(key, value string) {
+	if _, ok := p.values[key]; ok {
+		p.values[key] = nil
+	}
+	p.Add(key, value)
+}
+
+// Extend adds the parameters of other which are not set in p, e.g. those of a
+// configuration file which are not overridden on the command line.
+func (p *Params) Extend(other *Params) {
+	for _, k := range other.keys {
+		if !p.Has(k) {
+			for _, v := range other.values[k] {
+				p.Add(k, v)
+			}
+		}
+	}
+}
+
+// Keys returns the names of the parameters, in the order they were first
+// given.
+func (p *Params) Keys() []string {
+	return append([]string(nil), p.keys...)
+}
+
+// Has tells if the parameter is set.
+func (p *Params) Has(key string) bool {
+	_, ok := p.values[key]
+	return ok
+}
+
+// Lookup returns the (last) value of the parameter, and whether it is set.
+func (p *Params) Lookup(key string) (string, bool) {
+	values, ok := p.values[key]
+	if !ok {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// Get returns the (last) value of the parameter, or an empty string.
+func (p *Params) Get(key string) string {
+	v, _ := p.Lookup(key)
+	return v
+}
+
+// Values returns every value of the parameter, in order.
+func (p *Params) Values(key string) []string {
+	return append([]string(nil), p.values[key]...)
+}
+
+// List returns the items of the parameter's values, which are separated by
+// whitespace or commas (in quoted values), e.g. ["a", "b", "c"] for
+// `key="a, b",key=c`.
+func (p *Params) List(key string) []string {
+	var list []string
+	for _, v := range p.values[key] {
+		list = append(list, strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	return list
+}
+
+// Bool returns the boolean value of the parameter, which is false if it is not
+// set and true for a flag without a value. Otherwise the value is parsed by
+// strconv.ParseBool (e.g. "true", "false", "1", "0").
+func (p *Params) Bool(key string) (bool, error) {
+	v, ok := p.Lookup(key)
+	if !ok {
+		return false, nil
+	}
+	if v == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("parameter %s: invalid boolean %q", key, v)
+	}
+	return b, nil
+}
+
+// Int returns the integer value of the parameter, or zero if it is not set.
+func (p *Params) Int(key string) (int, error) {
+	v, ok := p.Lookup(key)
+	if !ok {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %s: invalid integer %q", key, v)
+	}
+	return i, nil
+}
+
+// Duration returns the duration value of the parameter (e.g. "1m30s", see
+// time.ParseDuration), or zero if it is not set.
+func (p *Params) Duration(key string) (time.Duration, error) {
+	v, ok := p.Lookup(key)
+	if !ok {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %s: invalid duration %q", key, v)
+	}
+	return d, nil
+}
+
+// Map returns a map of each parameter to its (last) value.
+func (p *Params) Map() map[string]string {
+	m := make(map[string]string, len(p.keys))
+	for k, values := range p.values {
+		m[k] = values[len(values)-1]
+	}
+	return m
+}
+
+// ParamType is the type of a parameter's values, see ParamSpec.
+type ParamType int
+
+const (
+	// StringParam parameters have arbitrary values.
+	StringParam ParamType = iota
+
+	// BoolParam parameters are flags, or have boolean values (see Params.Bool).
+	BoolParam
+
+	// IntParam parameters have integer values.
+	IntParam
+
+	// DurationParam parameters have duration values, e.g. "1m30s".
+	DurationParam
+
+	// ListParam parameters have lists of items as values (see Params.List),
+	// and may be repeated.
+	ListParam
+)
+
+// ParamSpec declares a plugin parameter, see ParamSchema.
+type ParamSpec struct {
+	// Name is the name of the parameter, or a pattern (see path.Match) of the
+	// names of a family of parameters, e.g. "apihost.*".
+	Name string
+
+	// Type is the type of the parameter's values.
+	Type ParamType
+
+	// Repeated is whether the parameter may be given more than once. ListParam
+	// parameters may always be repeated.
+	Repeated bool
+}
+
+// ParamSchema is the list of parameters a plugin accepts.
+type ParamSchema []ParamSpec
+
+// ParamError is a problem with a plugin parameter, found by
+// ParamSchema.Validate.
+type ParamError struct {
+	// Name is the name of the parameter.
+	Name string
+
+	// Unknown is whether the parameter is not declared by the schema, in which
+	// case Suggestion is the declared parameter with the most similar name, if
+	// any (e.g. "template" for "templte").
+	Unknown    bool
+	Suggestion string
+
+	// Msg is the error message.
+	Msg string
+}
+
+// Error implements the error interface.
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("parameter %s: %s", e.Name, e.Msg)
+}
+
+// ParamErrors is a list of errors found while validating parameters.
+type ParamErrors []*ParamError
+
+// Error implements the error interface, returning each error on its own line.
+func (e ParamErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks that the parameters are declared by the schema, have valid
+// values of their types, and are only repeated if allowed. If there are any
+// problems a ParamErrors list is returned, in the order of the parameters.
+func (s ParamSchema) Validate(p *Params) error {
+	var errs ParamErrors
+	for _, k := range p.keys {
+		spec := s.lookup(k)
+		if spec == nil {
+			err := &ParamError{Name: k, Unknown: true, Msg: "unknown parameter"}
+			if err.Suggestion = s.suggest(k); err.Suggestion != "" {
+				err.Msg += fmt.Sprintf(" (did you mean %s?)", err.Suggestion)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if n := len(p.values[k]); n > 1 && !spec.Repeated && spec.Type != ListParam {
+			errs = append(errs, &ParamError{Name: k, Msg: fmt.Sprintf("given %d times", n)})
+		}
+		var err error
+		switch spec.Type {
+		case BoolParam:
+			_, err = p.Bool(k)
+		case IntParam:
+			_, err = p.Int(k)
+		case DurationParam:
+			_, err = p.Duration(k)
+		}
+		if err != nil {
+			msg := strings.TrimPrefix(err.Error(), "parameter "+k+": ")
+			errs = append(errs, &ParamError{Name: k, Msg: msg})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// lookup returns the spec of the named parameter, or nil.
+func (s ParamSchema) lookup(name string) *ParamSpec {
+	for i, spec := range s {
+		if ok, _ := path.Match(spec.Name, name); ok || spec.Name == name {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// suggest returns the name of the declared parameter most similar to the
+// unknown one, or an empty string if none is similar enough to be a likely
+// misspelling.
+func (s ParamSchema) suggest(name string) string {
+	var (
+		best     string
+		bestDist = 3
+	)
+	for _, spec := range s {
+		if strings.ContainsAny(spec.Name, "*?[") {
+			continue
+		}
+		if d := editDistance(name, spec.Name); d < bestDist && d <= len(name)/2 {
+			best, bestDist = spec.Name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// min3 returns the smallest of the integers.
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseParamString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string][]string
+	}{
+		{"", map[string][]string{}},
+		{" , ,", map[string][]string{}},
+		{"flag,key=value", map[string][]string{"flag": {""}, "key": {"value"}}},
+		{`a=1,b=x=y,a=2`, map[string][]string{"a": {"1", "2"}, "b": {"x=y"}}},
+		{`title="Library, API",path=' a\b '`, map[string][]string{"title": {"Library, API"}, "path": {` a\b `}}},
+		{`q="say \"hi\" \\ \n",empty=""`, map[string][]string{"q": {`say "hi" \ \n`}, "empty": {""}}},
+		{`list=a\,b,title=Bob's API`, map[string][]string{"list": {"a,b"}, "title": {"Bob's API"}}},
+		{"a=1\nb = 2 \r\n", map[string][]string{"a": {"1"}, "b": {"2"}}},
+		{`dir=C:\templates\`, map[string][]string{"dir": {`C:\templates\`}}},
+	}
+	for _, tt := range tests {
+		p, err := ParseParamString(tt.in)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.in, err)
+		}
+		got := make(map[string][]string)
+		for _, k := range p.Keys() {
+			got[k] = p.Values(k)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%q: got %q want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`a="b`, `a='b,c`, `=value`} {
+		if _, err := ParseParamString(in); err == nil {
+			t.Fatalf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestParamsAccessors(t *testing.T) {
+	p, err := ParseParamString(`strict,keep-going=false,n=3,timeout=1m30s,exclude="a.* b.*",exclude=c.Internal,x=y`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := p.Bool("strict"); err != nil || !b {
+		t.Fatalf("strict: got %v, %v", b, err)
+	}
+	if b, err := p.Bool("keep-going"); err != nil || b {
+		t.Fatalf("keep-going: got %v, %v", b, err)
+	}
+	if b, err := p.Bool("missing"); err != nil || b {
+		t.Fatalf("missing: got %v, %v", b, err)
+	}
+	if _, err := p.Bool("x"); err == nil {
+		t.Fatal("x: expected an error")
+	}
+	if n, err := p.Int("n"); err != nil || n != 3 {
+		t.Fatalf("n: got %v, %v", n, err)
+	}
+	if d, err := p.Duration("timeout"); err != nil || d != 90*time.Second {
+		t.Fatalf("timeout: got %v, %v", d, err)
+	}
+	if got := strings.Join(p.List("exclude"), " "); got != "a.* b.* c.Internal" {
+		t.Fatalf("exclude: got %q", got)
+	}
+	if got := p.Get("exclude"); got != "c.Internal" {
+		t.Fatalf("exclude: got last value %q", got)
+	}
+
+	// Parameters of a configuration file don't override those already set.
+	conf, _ := ParseParamString("x=z,layout=package")
+	p.Extend(conf)
+	if p.Get("x") != "y" || p.Get("layout") != "package" {
+		t.Fatalf("got %v", p.Map())
+	}
+}
+
+func TestParamSchema(t *testing.T) {
+	schema := ParamSchema{
+		{Name: "template"},
+		{Name: "strict", Type: BoolParam},
+		{Name: "indent", Type: IntParam},
+		{Name: "exclude", Type: ListParam},
+		{Name: "apihost.*"},
+	}
+	p, err := ParseParamString("templte=a.html,strict=maybe,indent=2,indent=4,exclude=a,exclude=b,apihost.prod=x,style=dark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = schema.Validate(p)
+	errs, ok := err.(ParamErrors)
+	if !ok {
+		t.Fatalf("got %v, expected ParamErrors", err)
+	}
+	want := []string{
+		"parameter templte: unknown parameter (did you mean template?)",
+		`parameter strict: invalid boolean "maybe"`,
+		"parameter indent: given 2 times",
+		"parameter style: unknown parameter",
+	}
+	if got := errs.Error(); got != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	if errs[0].Suggestion != "template" || !errs[3].Unknown || errs[3].Suggestion != "" {
+		t.Fatalf("got %+v and %+v", errs[0], errs[3])
+	}
+
+	p, _ = ParseParamString("template=a.html,strict")
+	if err := schema.Validate(p); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"path"
+	"sort"
 	"strings"
 
 	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
@@ -12,24 +13,12 @@ import (
 )
 
 // ParseParams parses the comma-separated command-line parameters passed to the
-// generator by protoc via r.GetParameters. Returned is a map of key=value
-// parameters with whitespace preserved.
+// generator by protoc via r.GetParameters (see ParseParamString). Returned is a
+// map of key=value parameters, with the last value of repeated parameters.
+// Invalid parameters (e.g. with an unterminated quote) are ignored.
 func ParseParams(r *plugin.CodeGeneratorRequest) map[string]string {
-	// Split the parameter string and initialize the map.
-	split := strings.Split(r.GetParameter(), ",")
-	param := make(map[string]string, len(split))
-
-	// Map the parameters.
-	for _, p := range split {
-		eq := strings.Split(p, "=")
-		if len(eq) == 1 {
-			param[strings.TrimSpace(eq[0])] = ""
-			continue
-		}
-		val := strings.TrimSpace(eq[1])
-		param[strings.TrimSpace(eq[0])] = val
-	}
-	return param
+	p, _ := ParseParamString(r.GetParameter())
+	return p.Map()
 }
 
 // FieldTypeName returns the protobuf-syntax name for the given field type. It
@@ -162,6 +151,40 @@ func PackageName(f *descriptor.FileDescriptorProto) string {
 	return strings.TrimSuffix(pkg, path.Ext(pkg))
 }
 
+// Package is a single protobuf package, which may be declared across many
+// files.
+type Package struct {
+	// Name is the name of the package, as returned by PackageName.
+	Name string
+
+	// File is the list of files that declare the package, in the order they
+	// were given.
+	File []*descriptor.FileDescriptorProto
+}
+
+// Packages groups the given files by their package name (see PackageName),
+// returning the packages sorted by name.
+func Packages(files []*descriptor.FileDescriptorProto) []*Package {
+	var (
+		pkgs   []*Package
+		byName = make(map[string]*Package)
+	)
+	for _, f := range files {
+		name := PackageName(f)
+		pkg, ok := byName[name]
+		if !ok {
+			pkg = &Package{Name: name}
+			byName[name] = pkg
+			pkgs = append(pkgs, pkg)
+		}
+		pkg.File = append(pkg.File, f)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].Name < pkgs[j].Name
+	})
+	return pkgs
+}
+
 // ReadJSONFile opens and unmarshals the JSON dump file from the protoc-gen-json
 // plugin, returning any error that occurs.
 func ReadJSONFile(path string) (*plugin.CodeGeneratorRequest, error) {
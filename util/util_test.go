@@ -26,6 +26,15 @@ func TestParseParams(t *testing.T) {
 	}
 }
 
+func TestParseParamsEquals(t *testing.T) {
+	params := ParseParams(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("exclude-option=mycorp.visibility=INTERNAL"),
+	})
+	if got := params["exclude-option"]; got != "mycorp.visibility=INTERNAL" {
+		t.Fatalf("got %q", got)
+	}
+}
+
 func TestIsFullyQualified(t *testing.T) {
 	tests := map[string]bool{
 		".google.protobuf.UninterpretedOption": true,
@@ -108,3 +117,37 @@ func TestPackageName(t *testing.T) {
 		t.Fatalf("expected derived package name \"file\", got %q\n", got)
 	}
 }
+
+func TestPackages(t *testing.T) {
+	files := []*descriptor.FileDescriptorProto{
+		{Name: proto.String("b/one.proto"), Package: proto.String("b")},
+		{Name: proto.String("a/one.proto"), Package: proto.String("a")},
+		{Name: proto.String("b/two.proto"), Package: proto.String("b")},
+		{Name: proto.String("c.proto")},
+	}
+	pkgs := Packages(files)
+	want := []struct {
+		name  string
+		files []string
+	}{
+		{"a", []string{"a/one.proto"}},
+		{"b", []string{"b/one.proto", "b/two.proto"}},
+		{"c", []string{"c.proto"}},
+	}
+	if len(pkgs) != len(want) {
+		t.Fatalf("got %d packages want %d", len(pkgs), len(want))
+	}
+	for i, w := range want {
+		if pkgs[i].Name != w.name {
+			t.Fatalf("%d. got package %q want %q", i, pkgs[i].Name, w.name)
+		}
+		if len(pkgs[i].File) != len(w.files) {
+			t.Fatalf("%d. got %d files want %d", i, len(pkgs[i].File), len(w.files))
+		}
+		for j, f := range w.files {
+			if got := pkgs[i].File[j].GetName(); got != f {
+				t.Fatalf("%d. got file %q want %q", i, got, f)
+			}
+		}
+	}
+}